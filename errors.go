@@ -0,0 +1,21 @@
+package ipam
+
+import "fmt"
+
+// ErrNotFound is returned when a prefix or ip could not be found in the backing store.
+type ErrNotFound struct {
+	msg string
+}
+
+func (o ErrNotFound) Error() string { return o.msg }
+
+// NewNotFoundError creates a new ErrNotFound with the given formatted message.
+func NewNotFoundError(format string, args ...interface{}) error {
+	return ErrNotFound{msg: fmt.Sprintf(format, args...)}
+}
+
+// IsNotFound checks if the given error is an ErrNotFound.
+func IsNotFound(e error) bool {
+	_, ok := e.(ErrNotFound)
+	return ok
+}
@@ -0,0 +1,386 @@
+package ipam
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"time"
+
+	ipamstorage "github.com/metal-pod/go-ipam/storage"
+)
+
+// maxAcquireRetries bounds the optimistic-locking retry loop used by
+// AcquireChildPrefix/ReleaseChildPrefix/AcquireIP/ReleaseIP so a pathologically
+// contended prefix fails fast instead of retrying forever.
+const maxAcquireRetries = 10
+
+// withRetry re-reads cidr and calls mutate until it either succeeds, fails
+// with a non-conflict error, or maxAcquireRetries is exhausted. Storage
+// backends that implement ipamstorage.PessimisticLocker and have it enabled
+// run the read-modify-write under a lock instead, skipping the retry loop
+// entirely. Backoff for the retry path is exponential with jitter so that
+// goroutines racing for the same parent prefix don't lock-step retry forever.
+func (i *ipamer) withRetry(cidr string, mutate func(*Prefix) (*Prefix, error)) (*Prefix, error) {
+	if pl, ok := i.storage.(ipamstorage.PessimisticLocker); ok && pl.LockingEnabled() {
+		updated, err := pl.UpdatePrefixWithLock(cidr, func(sp ipamstorage.Prefix) (ipamstorage.Prefix, error) {
+			mutated, err := mutate(fromStorage(sp))
+			if err != nil {
+				return ipamstorage.Prefix{}, err
+			}
+			return toStorage(mutated), nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return fromStorage(updated), nil
+	}
+
+	backoff := 10 * time.Millisecond
+	for attempt := 0; attempt < maxAcquireRetries; attempt++ {
+		current, err := i.storage.ReadPrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read prefix:%w", err)
+		}
+		mutated, err := mutate(fromStorage(current))
+		if err != nil {
+			return nil, err
+		}
+		updated, err := i.storage.UpdatePrefix(toStorage(mutated))
+		if err == nil {
+			return fromStorage(updated), nil
+		}
+		if !IsOptimisticLockError(err) {
+			return nil, err
+		}
+		jitter := time.Duration(rand.Int63n(int64(backoff)))
+		time.Sleep(backoff + jitter)
+		backoff *= 2
+	}
+	return nil, fmt.Errorf("unable to update prefix:%s after %d retries, too much concurrent modification", cidr, maxAcquireRetries)
+}
+
+// Prefix is a expression of a network implemented as a Cidr.
+type Prefix struct {
+	Cidr                   string          // The Cidr of this prefix
+	ParentCidr             string          // if this prefix is a child this is a pointer back
+	availableChildPrefixes map[string]bool // available child prefixes of this prefix
+	childPrefixLength      int             // the length of the child prefixes
+	ips                    map[string]bool // The ips contained in this prefix
+	version                int64           // version of this prefix in the storage, used for optimistic locking
+	leasedUntil            time.Time       // zero if this prefix is not leased, otherwise when the lease expires
+	leaseToken             string          // opaque token identifying the lease, used to renew/release it
+}
+
+// toStorage converts a Prefix to the storage.Prefix representation a Storage
+// backend persists.
+func toStorage(p *Prefix) ipamstorage.Prefix {
+	return ipamstorage.Prefix{
+		Cidr:                   p.Cidr,
+		ParentCidr:             p.ParentCidr,
+		AvailableChildPrefixes: p.availableChildPrefixes,
+		ChildPrefixLength:      p.childPrefixLength,
+		IPs:                    p.ips,
+		Version:                p.version,
+		LeasedUntil:            p.leasedUntil,
+		LeaseToken:             p.leaseToken,
+	}
+}
+
+// fromStorage converts a storage.Prefix as loaded from a Storage backend
+// back into a Prefix.
+func fromStorage(sp ipamstorage.Prefix) *Prefix {
+	return &Prefix{
+		Cidr:                   sp.Cidr,
+		ParentCidr:             sp.ParentCidr,
+		availableChildPrefixes: sp.AvailableChildPrefixes,
+		childPrefixLength:      sp.ChildPrefixLength,
+		ips:                    sp.IPs,
+		version:                sp.Version,
+		leasedUntil:            sp.LeasedUntil,
+		leaseToken:             sp.LeaseToken,
+	}
+}
+
+// Ipamer can be used to do IPAM stuff.
+type Ipamer interface {
+	// NewPrefix creates a new Prefix from a cidr.
+	NewPrefix(cidr string) (*Prefix, error)
+	// DeletePrefix deletes a Prefix from a cidr.
+	DeletePrefix(cidr string) (*Prefix, error)
+	// AcquireChildPrefix will return a Prefix with a smaller length from the given Prefix.
+	AcquireChildPrefix(parentPrefix *Prefix, length int) (*Prefix, error)
+	// ReleaseChildPrefix will mark this child Prefix as available again.
+	ReleaseChildPrefix(child *Prefix) error
+	// PrefixFrom will return the Prefix from the storage or nil if not found.
+	PrefixFrom(cidr string) *Prefix
+	// AcquireIP will return the next unused IP from this Prefix.
+	AcquireIP(prefix *Prefix) (*IP, error)
+	// ReleaseIP will release the given IP for later usage.
+	ReleaseIP(ip *IP) error
+	// AcquireChildPrefixWithLease acquires a child Prefix like AcquireChildPrefix,
+	// but marks it leased until ttl elapses. A caller that never follows up with
+	// RenewLease or ReleaseLease does not leak the prefix forever: an Ipamer
+	// created with WithLeaseReaper reclaims it once the lease expires.
+	AcquireChildPrefixWithLease(parentPrefix *Prefix, length int, ttl time.Duration) (*Lease, error)
+	// RenewLease extends the lease identified by token by ttl, counted from now.
+	RenewLease(token string, ttl time.Duration) error
+	// ReleaseLease releases the prefix held by the lease identified by token,
+	// returning it to its parent's available pool.
+	ReleaseLease(token string) error
+	// Dump writes the entire prefix tree as a stable, versioned JSON document
+	// to w. The result can be restored into any Storage backend with Load.
+	Dump(w io.Writer) error
+	// Load replaces the Storage's content with a document previously written
+	// by Dump. It refuses to run against a non-empty store unless WithOverwrite
+	// is passed.
+	Load(r io.Reader, opts ...LoadOption) error
+}
+
+// LoadOption configures Ipamer.Load.
+type LoadOption func(*loadOptions)
+
+type loadOptions struct {
+	overwrite bool
+}
+
+// WithOverwrite allows Load to replace the content of a non-empty store.
+// Without it, Load refuses to run so an operator can't accidentally clobber
+// an existing deployment's prefixes with a stale dump.
+func WithOverwrite() LoadOption {
+	return func(o *loadOptions) {
+		o.overwrite = true
+	}
+}
+
+// IP is a single ipaddress.
+type IP struct {
+	IP           net.IP
+	ParentPrefix string
+}
+
+type ipamer struct {
+	storage      Storage
+	reapInterval time.Duration
+}
+
+// IpamerOption configures an Ipamer created by NewWithStorage.
+type IpamerOption func(*ipamer)
+
+// WithLeaseReaper starts a background goroutine that sweeps expired leases
+// every interval, returning their prefixes to the parent's available pool.
+// Without it, a lease whose caller never calls RenewLease or ReleaseLease
+// stays leased after it expires; nothing else in this package reclaims it.
+func WithLeaseReaper(interval time.Duration) IpamerOption {
+	return func(i *ipamer) {
+		i.reapInterval = interval
+	}
+}
+
+// NewWithStorage creates a new Ipamer with the given Storage.
+func NewWithStorage(storage Storage, opts ...IpamerOption) Ipamer {
+	i := &ipamer{storage: storage}
+	for _, opt := range opts {
+		opt(i)
+	}
+	if i.reapInterval > 0 {
+		go i.reapExpiredLeases()
+	}
+	return i
+}
+
+// NewPrefix creates a new Prefix and persists it to the Storage.
+func (i *ipamer) NewPrefix(cidr string) (*Prefix, error) {
+	_, _, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse cidr:%s %w", cidr, err)
+	}
+	p := &Prefix{
+		Cidr:                   cidr,
+		availableChildPrefixes: make(map[string]bool),
+		ips:                    make(map[string]bool),
+	}
+	newPrefix, err := i.storage.CreatePrefix(toStorage(p))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create prefix:%w", err)
+	}
+	return fromStorage(newPrefix), nil
+}
+
+// DeletePrefix removes a Prefix from the Storage.
+func (i *ipamer) DeletePrefix(cidr string) (*Prefix, error) {
+	p := i.PrefixFrom(cidr)
+	if p == nil {
+		return nil, NewNotFoundError("unable to find prefix for cidr:%s", cidr)
+	}
+	deleted, err := i.storage.DeletePrefix(toStorage(p))
+	if err != nil {
+		return nil, err
+	}
+	return fromStorage(deleted), nil
+}
+
+// PrefixFrom reads a Prefix from the Storage, returns nil if not found.
+func (i *ipamer) PrefixFrom(cidr string) *Prefix {
+	p, err := i.storage.ReadPrefix(cidr)
+	if err != nil {
+		return nil
+	}
+	return fromStorage(p)
+}
+
+// AcquireChildPrefix returns a free child Prefix of the given length out of the parentPrefix.
+// The parent's available-child bookkeeping is updated under optimistic (or,
+// for backends that opt in, pessimistic) locking so concurrent callers racing
+// for the same parent each get a distinct child instead of clobbering one
+// another's writes.
+func (i *ipamer) AcquireChildPrefix(parentPrefix *Prefix, length int) (*Prefix, error) {
+	return i.acquireChildPrefix(parentPrefix, length, nil)
+}
+
+// acquireChildPrefix reserves a free child cidr of length in parentPrefix
+// (one write to the parent), then persists the child as a new Prefix in a
+// single further storage write. configure, if
+// non-nil, is applied to the child before that write, so a caller like
+// AcquireChildPrefixWithLease can have the lease fields already set on the
+// same CreatePrefix call instead of a second, independently-durable write
+// that could leak the reservation if it never completes. If the write
+// itself fails, the reservation is rolled back so the cidr isn't leaked as
+// permanently taken.
+func (i *ipamer) acquireChildPrefix(parentPrefix *Prefix, length int, configure func(*Prefix)) (*Prefix, error) {
+	var acquired string
+	_, err := i.withRetry(parentPrefix.Cidr, func(parent *Prefix) (*Prefix, error) {
+		if parent.availableChildPrefixes == nil {
+			parent.availableChildPrefixes = make(map[string]bool)
+		}
+		for cidr, free := range parent.availableChildPrefixes {
+			if free {
+				parent.availableChildPrefixes[cidr] = false
+				acquired = cidr
+				return parent, nil
+			}
+		}
+		found := false
+		err := childPrefixCandidates(parent, length, func(cidr string) bool {
+			if _, taken := parent.availableChildPrefixes[cidr]; taken {
+				return true
+			}
+			parent.availableChildPrefixes[cidr] = false
+			acquired = cidr
+			found = true
+			return false
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return nil, fmt.Errorf("no more child prefixes of length %d left in %s", length, parent.Cidr)
+		}
+		return parent, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to acquire child prefix:%w", err)
+	}
+
+	child := &Prefix{
+		Cidr:                   acquired,
+		ParentCidr:             parentPrefix.Cidr,
+		availableChildPrefixes: make(map[string]bool),
+		ips:                    make(map[string]bool),
+	}
+	if configure != nil {
+		configure(child)
+	}
+	created, err := i.storage.CreatePrefix(toStorage(child))
+	if err != nil {
+		_, _ = i.withRetry(parentPrefix.Cidr, func(parent *Prefix) (*Prefix, error) {
+			parent.availableChildPrefixes[acquired] = true
+			return parent, nil
+		})
+		return nil, err
+	}
+	return fromStorage(created), nil
+}
+
+// ReleaseChildPrefix marks a previously acquired child Prefix as available again.
+func (i *ipamer) ReleaseChildPrefix(child *Prefix) error {
+	if err := i.markChildAvailable(child.ParentCidr, child.Cidr); err != nil {
+		return fmt.Errorf("unable to release child prefix:%w", err)
+	}
+	_, err := i.storage.DeletePrefix(toStorage(child))
+	return err
+}
+
+// markChildAvailable marks childCidr as free again in parentCidr's
+// available-child bookkeeping, without touching the child's own storage row.
+func (i *ipamer) markChildAvailable(parentCidr, childCidr string) error {
+	_, err := i.withRetry(parentCidr, func(parent *Prefix) (*Prefix, error) {
+		if _, ok := parent.availableChildPrefixes[childCidr]; !ok {
+			return nil, NewNotFoundError("unable to find child prefix:%s in parent:%s", childCidr, parentCidr)
+		}
+		parent.availableChildPrefixes[childCidr] = true
+		return parent, nil
+	})
+	return err
+}
+
+// AcquireIP returns the next free IP of the given Prefix.
+func (i *ipamer) AcquireIP(prefix *Prefix) (*IP, error) {
+	_, ipnet, err := net.ParseCIDR(prefix.Cidr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse cidr:%s %w", prefix.Cidr, err)
+	}
+	var acquired net.IP
+	_, err = i.withRetry(prefix.Cidr, func(p *Prefix) (*Prefix, error) {
+		if p.ips == nil {
+			p.ips = make(map[string]bool)
+		}
+		ip := make(net.IP, len(ipnet.IP))
+		copy(ip, ipnet.IP)
+		for ipnet.Contains(ip) {
+			if !p.ips[ip.String()] {
+				p.ips[ip.String()] = true
+				acquired = make(net.IP, len(ip))
+				copy(acquired, ip)
+				return p, nil
+			}
+			addToIP(ip, 1)
+		}
+		return nil, fmt.Errorf("no more ips left in prefix %s", prefix.Cidr)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to acquire ip:%w", err)
+	}
+	return &IP{IP: acquired, ParentPrefix: prefix.Cidr}, nil
+}
+
+// ReleaseIP releases a previously acquired IP.
+func (i *ipamer) ReleaseIP(ip *IP) error {
+	_, err := i.withRetry(ip.ParentPrefix, func(p *Prefix) (*Prefix, error) {
+		delete(p.ips, ip.IP.String())
+		return p, nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to release ip:%w", err)
+	}
+	return nil
+}
+
+// Dump writes the entire prefix tree as a stable, versioned JSON document to w.
+func (i *ipamer) Dump(w io.Writer) error {
+	return i.storage.Dump(w)
+}
+
+// Load replaces the Storage's content with a document previously written by Dump.
+func (i *ipamer) Load(r io.Reader, opts ...LoadOption) error {
+	var o loadOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return i.storage.Restore(r, o.overwrite)
+}
+
+// String implements the Stringer interface.
+func (p *Prefix) String() string {
+	return p.Cidr
+}
@@ -0,0 +1,168 @@
+package ipam
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/metal-pod/go-ipam/storage"
+	"github.com/metal-pod/go-ipam/storage/memory"
+)
+
+func Test_Ipamer_AcquireChildPrefix(t *testing.T) {
+	ipamer := NewWithStorage(memory.New())
+
+	parent, err := ipamer.NewPrefix("192.168.0.0/24")
+	require.Nil(t, err)
+	require.NotNil(t, parent)
+
+	child, err := ipamer.AcquireChildPrefix(parent, 25)
+	require.Nil(t, err)
+	require.NotNil(t, child)
+	require.Equal(t, parent.Cidr, child.ParentCidr)
+
+	second, err := ipamer.AcquireChildPrefix(parent, 25)
+	require.Nil(t, err)
+	require.NotEqual(t, child.Cidr, second.Cidr)
+
+	_, err = ipamer.AcquireChildPrefix(parent, 25)
+	require.NotNil(t, err)
+
+	err = ipamer.ReleaseChildPrefix(child)
+	require.Nil(t, err)
+
+	again, err := ipamer.AcquireChildPrefix(parent, 25)
+	require.Nil(t, err)
+	require.Equal(t, child.Cidr, again.Cidr)
+}
+
+func Test_Ipamer_AcquireIP(t *testing.T) {
+	ipamer := NewWithStorage(memory.New())
+
+	prefix, err := ipamer.NewPrefix("192.168.0.0/30")
+	require.Nil(t, err)
+
+	ip, err := ipamer.AcquireIP(prefix)
+	require.Nil(t, err)
+	require.NotNil(t, ip)
+	require.Equal(t, "192.168.0.0", ip.IP.String())
+
+	second, err := ipamer.AcquireIP(prefix)
+	require.Nil(t, err)
+	require.Equal(t, "192.168.0.1", second.IP.String())
+
+	err = ipamer.ReleaseIP(ip)
+	require.Nil(t, err)
+
+	reacquired, err := ipamer.AcquireIP(prefix)
+	require.Nil(t, err)
+	require.Equal(t, "192.168.0.0", reacquired.IP.String())
+}
+
+func Test_Ipamer_DumpLoad(t *testing.T) {
+	ipamer := NewWithStorage(memory.New())
+
+	parent, err := ipamer.NewPrefix("192.168.0.0/24")
+	require.Nil(t, err)
+	_, err = ipamer.AcquireChildPrefix(parent, 25)
+	require.Nil(t, err)
+
+	var buf bytes.Buffer
+	err = ipamer.Dump(&buf)
+	require.Nil(t, err)
+
+	// Loading into the same, non-empty store without WithOverwrite must fail.
+	err = ipamer.Load(bytes.NewReader(buf.Bytes()))
+	require.NotNil(t, err)
+	require.True(t, storage.IsNotEmpty(err))
+
+	restored := NewWithStorage(memory.New())
+	err = restored.Load(bytes.NewReader(buf.Bytes()))
+	require.Nil(t, err)
+
+	got := restored.PrefixFrom("192.168.0.0/24")
+	require.NotNil(t, got)
+
+	err = ipamer.Load(bytes.NewReader(buf.Bytes()), WithOverwrite())
+	require.Nil(t, err)
+}
+
+func Test_Ipamer_Lease(t *testing.T) {
+	ipamer := NewWithStorage(memory.New())
+
+	parent, err := ipamer.NewPrefix("192.168.0.0/24")
+	require.Nil(t, err)
+
+	lease, err := ipamer.AcquireChildPrefixWithLease(parent, 25, time.Minute)
+	require.Nil(t, err)
+	require.NotNil(t, lease)
+	require.NotEmpty(t, lease.Token)
+
+	err = ipamer.RenewLease(lease.Token, time.Minute)
+	require.Nil(t, err)
+
+	err = ipamer.ReleaseLease(lease.Token)
+	require.Nil(t, err)
+
+	// Released, so the same child cidr can be leased again.
+	again, err := ipamer.AcquireChildPrefixWithLease(parent, 25, time.Minute)
+	require.Nil(t, err)
+	require.Equal(t, lease.Prefix.Cidr, again.Prefix.Cidr)
+
+	// A /24 only has two /25s; lease out the other one too so the pool is
+	// actually exhausted.
+	_, err = ipamer.AcquireChildPrefixWithLease(parent, 25, time.Minute)
+	require.Nil(t, err)
+
+	_, err = ipamer.AcquireChildPrefixWithLease(parent, 25, time.Minute)
+	require.NotNil(t, err)
+}
+
+// fakeOptimisticStorage wraps a memory Storage but makes its first
+// `failures` calls to UpdatePrefix return storage.ErrOptimisticLockFailed
+// before delegating to the real implementation. It deliberately does not
+// promote memory's PessimisticLocker methods, so withRetry falls through to
+// its optimistic retry loop instead of the pessimistic-lock fast path -
+// letting that loop be exercised without a real concurrent writer or a live
+// database.
+type fakeOptimisticStorage struct {
+	storage.Storage
+	failures int
+}
+
+func (f *fakeOptimisticStorage) UpdatePrefix(prefix storage.Prefix) (storage.Prefix, error) {
+	if f.failures > 0 {
+		f.failures--
+		return storage.Prefix{}, storage.NewOptimisticLockError(prefix.Cidr)
+	}
+	return f.Storage.UpdatePrefix(prefix)
+}
+
+func Test_Ipamer_withRetry_OptimisticRetry(t *testing.T) {
+	fake := &fakeOptimisticStorage{Storage: memory.New(), failures: 3}
+	ipamer := NewWithStorage(fake)
+
+	parent, err := ipamer.NewPrefix("10.0.0.0/24")
+	require.Nil(t, err)
+
+	child, err := ipamer.AcquireChildPrefix(parent, 25)
+	require.Nil(t, err)
+	require.NotNil(t, child)
+	require.Equal(t, 0, fake.failures)
+}
+
+func Test_Ipamer_LeaseReaper(t *testing.T) {
+	ipamer := NewWithStorage(memory.New(), WithLeaseReaper(10*time.Millisecond))
+
+	parent, err := ipamer.NewPrefix("192.168.0.0/24")
+	require.Nil(t, err)
+
+	lease, err := ipamer.AcquireChildPrefixWithLease(parent, 25, time.Millisecond)
+	require.Nil(t, err)
+
+	require.Eventually(t, func() bool {
+		return ipamer.PrefixFrom(lease.Prefix.Cidr) == nil
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,152 @@
+package ipam
+
+import (
+	"crypto/rand"
+	"fmt"
+	"time"
+
+	ipamstorage "github.com/metal-pod/go-ipam/storage"
+)
+
+// Lease is a Prefix reserved for a limited time, as returned by
+// AcquireChildPrefixWithLease. It lets a caller reserve address space before
+// the thing that will use it (a pod, a machine) has actually come up,
+// without permanently leaking the prefix if that caller crashes mid-setup.
+type Lease struct {
+	Prefix    *Prefix
+	ExpiresAt time.Time
+	Token     string
+}
+
+// newLeaseToken generates an opaque token identifying a Lease. It is
+// formatted as a lowercase, hyphenated UUID so it reads back unchanged from
+// a uuid-typed storage column.
+func newLeaseToken() (string, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("unable to generate lease token:%w", err)
+	}
+	return fmt.Sprintf("%x-%x-%x-%x-%x", raw[0:4], raw[4:6], raw[6:8], raw[8:10], raw[10:16]), nil
+}
+
+// AcquireChildPrefixWithLease acquires a child Prefix like AcquireChildPrefix,
+// leased until ttl elapses. Creating the child and marking its lease are a
+// single storage write, not two: a crash or error between them could otherwise
+// leave the child durably acquired with a zero ExpiresAt that the reaper
+// never selects, leaking it forever.
+func (i *ipamer) AcquireChildPrefixWithLease(parentPrefix *Prefix, length int, ttl time.Duration) (*Lease, error) {
+	token, err := newLeaseToken()
+	if err != nil {
+		return nil, err
+	}
+	expiresAt := time.Now().Add(ttl)
+	child, err := i.acquireChildPrefix(parentPrefix, length, func(p *Prefix) {
+		p.leasedUntil = expiresAt
+		p.leaseToken = token
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to lease child prefix:%w", err)
+	}
+	return &Lease{Prefix: child, ExpiresAt: expiresAt, Token: token}, nil
+}
+
+// RenewLease extends the lease identified by token by ttl, counted from now.
+func (i *ipamer) RenewLease(token string, ttl time.Duration) error {
+	cidr, err := i.findLeasedCidr(token)
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(ttl)
+	_, err = i.withRetry(cidr, func(p *Prefix) (*Prefix, error) {
+		if p.leaseToken != token {
+			return nil, NewNotFoundError("unable to find lease for token:%s", token)
+		}
+		p.leasedUntil = expiresAt
+		return p, nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to renew lease:%w", err)
+	}
+	return nil
+}
+
+// ReleaseLease releases the prefix held by the lease identified by token,
+// returning it to its parent's available pool.
+func (i *ipamer) ReleaseLease(token string) error {
+	cidr, err := i.findLeasedCidr(token)
+	if err != nil {
+		return err
+	}
+	prefix := i.PrefixFrom(cidr)
+	if prefix == nil {
+		return NewNotFoundError("unable to find lease for token:%s", token)
+	}
+	return i.ReleaseChildPrefix(prefix)
+}
+
+// findLeasedCidr returns the cidr of the prefix currently leased under token.
+func (i *ipamer) findLeasedCidr(token string) (string, error) {
+	prefixes, err := i.storage.ReadAllPrefixes()
+	if err != nil {
+		return "", fmt.Errorf("unable to read prefixes:%w", err)
+	}
+	for _, p := range prefixes {
+		if p.LeaseToken == token {
+			return p.Cidr, nil
+		}
+	}
+	return "", NewNotFoundError("unable to find lease for token:%s", token)
+}
+
+// reapExpiredLeases runs reapOnce every i.reapInterval until the process exits.
+func (i *ipamer) reapExpiredLeases() {
+	ticker := time.NewTicker(i.reapInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		i.reapOnce()
+	}
+}
+
+// reapOnce returns every prefix whose lease has already expired to its
+// parent's available pool. Backends implementing ipamstorage.LeaseReader are
+// queried directly for expired leases; others are swept by scanning every
+// prefix. The snapshot this produces can go stale before a given cidr is
+// actually released, so the release itself never trusts it blindly: backends
+// implementing ipamstorage.LeaseReleaser re-check token and expiry at delete
+// time, atomically.
+func (i *ipamer) reapOnce() {
+	before := time.Now()
+	var expired []ipamstorage.Prefix
+	if lr, ok := i.storage.(ipamstorage.LeaseReader); ok {
+		leased, err := lr.ReadLeasedPrefixes(before)
+		if err != nil {
+			return
+		}
+		expired = leased
+	} else {
+		all, err := i.storage.ReadAllPrefixes()
+		if err != nil {
+			return
+		}
+		for _, p := range all {
+			if !p.LeasedUntil.IsZero() && p.LeasedUntil.Before(before) {
+				expired = append(expired, p)
+			}
+		}
+	}
+
+	releaser, canReleaseAtomically := i.storage.(ipamstorage.LeaseReleaser)
+	for _, sp := range expired {
+		if canReleaseAtomically {
+			deleted, released, err := releaser.ReleaseExpiredLease(sp.Cidr, sp.LeaseToken, before)
+			if err != nil || !released {
+				// Already renewed, released, or gone since the snapshot was
+				// taken: nothing left for the reaper to do here.
+				continue
+			}
+			_ = i.markChildAvailable(deleted.ParentCidr, deleted.Cidr)
+			continue
+		}
+		_ = i.ReleaseChildPrefix(fromStorage(sp))
+	}
+}
@@ -0,0 +1,47 @@
+package ipam
+
+import (
+	"fmt"
+	"net"
+)
+
+// childPrefixCandidates calls yield with every possible child Cidr of the
+// given length that fits inside parent, in ascending order, stopping as soon
+// as yield returns false. Candidates are generated one at a time instead of
+// materialized up front, so a caller that stops at the first free one (as
+// acquireChildPrefix does) never allocates or formats more than it needs to -
+// acquiring a /32 out of a /8, for instance, would otherwise format on the
+// order of hundreds of millions of strings before looking at a single one.
+func childPrefixCandidates(parent *Prefix, length int, yield func(cidr string) bool) error {
+	_, ipnet, err := net.ParseCIDR(parent.Cidr)
+	if err != nil {
+		return fmt.Errorf("unable to parse cidr:%s %w", parent.Cidr, err)
+	}
+	ones, bits := ipnet.Mask.Size()
+	if length <= ones || length > bits {
+		return fmt.Errorf("given length:%d is not greater than prefix length:%d", length, ones)
+	}
+
+	// Stepping to the next child adds 1<<hostBits. It is applied to the
+	// leading bytes only, so it does not overflow for IPv6 children with 64
+	// or more host bits.
+	hostBits := uint(bits - length)
+	ip := make(net.IP, len(ipnet.IP))
+	copy(ip, ipnet.IP)
+	for ipnet.Contains(ip) {
+		if !yield(fmt.Sprintf("%s/%d", ip.String(), length)) {
+			return nil
+		}
+		addToIP(ip[:len(ip)-int(hostBits/8)], uint64(1)<<(hostBits%8))
+	}
+	return nil
+}
+
+// addToIP adds n to ip, treating ip as a big-endian unsigned integer.
+func addToIP(ip net.IP, n uint64) {
+	for i := len(ip) - 1; i >= 0 && n > 0; i-- {
+		sum := uint64(ip[i]) + n
+		ip[i] = byte(sum)
+		n = sum >> 8
+	}
+}
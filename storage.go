@@ -0,0 +1,14 @@
+package ipam
+
+import ipamstorage "github.com/metal-pod/go-ipam/storage"
+
+// Storage is the interface every backing store must implement to persist
+// Prefixes. NewWithStorage accepts any implementation, see the ready-made
+// backends under storage/{postgres,bolt,etcd,memory}.
+type Storage = ipamstorage.Storage
+
+// IsOptimisticLockError checks if the given error is an optimistic-locking
+// conflict reported by a Storage backend.
+func IsOptimisticLockError(e error) bool {
+	return ipamstorage.IsOptimisticLockError(e)
+}
@@ -0,0 +1,286 @@
+// Package bolt is an embedded storage.Storage backend backed by bbolt,
+// suitable for single-node deployments that don't want to run a separate
+// database process.
+package bolt
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/metal-pod/go-ipam/storage"
+)
+
+var prefixesBucket = []byte("prefixes")
+
+// boltStorage is the storage.Storage implementation backed by a bbolt file.
+type boltStorage struct {
+	db *bolt.DB
+}
+
+// New opens (creating if necessary) a bbolt database at path and returns a
+// Storage that stores every Prefix as a JSON value under prefixesBucket,
+// keyed by Cidr.
+func New(path string) (*boltStorage, error) {
+	db, err := bolt.Open(path, 0o600, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open bolt database:%w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(prefixesBucket)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create prefixes bucket:%w", err)
+	}
+	return &boltStorage{db: db}, nil
+}
+
+// CreatePrefix persists a new Prefix, returning the unchanged Prefix on success.
+func (b *boltStorage) CreatePrefix(prefix storage.Prefix) (storage.Prefix, error) {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(prefixesBucket)
+		if existing := bucket.Get([]byte(prefix.Cidr)); existing != nil {
+			return nil
+		}
+		raw, err := json.Marshal(prefix)
+		if err != nil {
+			return fmt.Errorf("unable to marshal prefix:%w", err)
+		}
+		return bucket.Put([]byte(prefix.Cidr), raw)
+	})
+	if err != nil {
+		return storage.Prefix{}, fmt.Errorf("unable to create prefix:%w", err)
+	}
+	return prefix, nil
+}
+
+// ReadPrefix reads a Prefix by cidr. The returned Prefix carries the bucket
+// entry's current version so a subsequent UpdatePrefix can detect a
+// concurrent modification.
+func (b *boltStorage) ReadPrefix(cidr string) (storage.Prefix, error) {
+	var p storage.Prefix
+	err := b.db.View(func(tx *bolt.Tx) error {
+		raw := tx.Bucket(prefixesBucket).Get([]byte(cidr))
+		if raw == nil {
+			return storage.NewNotFoundError("unable to read prefix:%s", cidr)
+		}
+		return json.Unmarshal(raw, &p)
+	})
+	if err != nil {
+		return storage.Prefix{}, err
+	}
+	return p, nil
+}
+
+// ReadAllPrefixes returns every Prefix currently stored.
+func (b *boltStorage) ReadAllPrefixes() ([]storage.Prefix, error) {
+	var result []storage.Prefix
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(prefixesBucket).ForEach(func(_, raw []byte) error {
+			var p storage.Prefix
+			if err := json.Unmarshal(raw, &p); err != nil {
+				return err
+			}
+			result = append(result, p)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to read prefixes:%w", err)
+	}
+	return result, nil
+}
+
+// UpdatePrefix persists changes to an existing Prefix using optimistic
+// locking: the update only applies if the stored version still matches
+// prefix.Version, otherwise storage.ErrOptimisticLockFailed is returned so
+// the caller can re-read and retry the mutation.
+func (b *boltStorage) UpdatePrefix(prefix storage.Prefix) (storage.Prefix, error) {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(prefixesBucket)
+		raw := bucket.Get([]byte(prefix.Cidr))
+		if raw == nil {
+			return storage.NewNotFoundError("unable to update prefix:%s", prefix.Cidr)
+		}
+		var current storage.Prefix
+		if err := json.Unmarshal(raw, &current); err != nil {
+			return fmt.Errorf("unable to unmarshal prefix:%w", err)
+		}
+		if current.Version != prefix.Version {
+			return storage.NewOptimisticLockError(prefix.Cidr)
+		}
+		prefix.Version++
+		newRaw, err := json.Marshal(prefix)
+		if err != nil {
+			return fmt.Errorf("unable to marshal prefix:%w", err)
+		}
+		return bucket.Put([]byte(prefix.Cidr), newRaw)
+	})
+	if err != nil {
+		return storage.Prefix{}, err
+	}
+	return prefix, nil
+}
+
+// DeletePrefix removes a Prefix, returning the Prefix as it was before deletion.
+func (b *boltStorage) DeletePrefix(prefix storage.Prefix) (storage.Prefix, error) {
+	var deleted storage.Prefix
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(prefixesBucket)
+		raw := bucket.Get([]byte(prefix.Cidr))
+		if raw == nil {
+			return storage.NewNotFoundError("unable to delete prefix:%s", prefix.Cidr)
+		}
+		if err := json.Unmarshal(raw, &deleted); err != nil {
+			return fmt.Errorf("unable to unmarshal prefix:%w", err)
+		}
+		return bucket.Delete([]byte(prefix.Cidr))
+	})
+	if err != nil {
+		return storage.Prefix{}, err
+	}
+	return deleted, nil
+}
+
+// LockingEnabled always reports true: bbolt serializes all writers on a
+// single read-write transaction per database, so UpdatePrefixWithLock is
+// strictly cheaper than an optimistic retry loop that would just queue up
+// behind the same transaction lock anyway.
+func (b *boltStorage) LockingEnabled() bool {
+	return true
+}
+
+// UpdatePrefixWithLock runs mutate against the current value inside a single
+// bbolt read-write transaction, which is exclusive for the whole database, so
+// the read-modify-write is atomic.
+func (b *boltStorage) UpdatePrefixWithLock(cidr string, mutate func(storage.Prefix) (storage.Prefix, error)) (storage.Prefix, error) {
+	var result storage.Prefix
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(prefixesBucket)
+		raw := bucket.Get([]byte(cidr))
+		if raw == nil {
+			return storage.NewNotFoundError("unable to read prefix:%s", cidr)
+		}
+		var current storage.Prefix
+		if err := json.Unmarshal(raw, &current); err != nil {
+			return fmt.Errorf("unable to unmarshal prefix:%w", err)
+		}
+		mutated, err := mutate(current)
+		if err != nil {
+			return err
+		}
+		mutated.Version++
+		newRaw, err := json.Marshal(mutated)
+		if err != nil {
+			return fmt.Errorf("unable to marshal prefix:%w", err)
+		}
+		if err := bucket.Put([]byte(cidr), newRaw); err != nil {
+			return err
+		}
+		result = mutated
+		return nil
+	})
+	if err != nil {
+		return storage.Prefix{}, err
+	}
+	return result, nil
+}
+
+// ReleaseExpiredLease atomically deletes the prefix at cidr if it is still
+// held by token and its lease was still expired as of before, returning the
+// deleted Prefix and true. If the entry was renewed, released, or is already
+// gone, it returns false without deleting anything.
+func (b *boltStorage) ReleaseExpiredLease(cidr, token string, before time.Time) (storage.Prefix, bool, error) {
+	var deleted storage.Prefix
+	var released bool
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(prefixesBucket)
+		raw := bucket.Get([]byte(cidr))
+		if raw == nil {
+			return nil
+		}
+		var current storage.Prefix
+		if err := json.Unmarshal(raw, &current); err != nil {
+			return fmt.Errorf("unable to unmarshal prefix:%w", err)
+		}
+		if current.LeaseToken != token || current.LeasedUntil.IsZero() || !current.LeasedUntil.Before(before) {
+			return nil
+		}
+		if err := bucket.Delete([]byte(cidr)); err != nil {
+			return err
+		}
+		deleted = current
+		released = true
+		return nil
+	})
+	if err != nil {
+		return storage.Prefix{}, false, err
+	}
+	return deleted, released, nil
+}
+
+// Dump writes every stored Prefix as a storage.Dump document to w.
+func (b *boltStorage) Dump(w io.Writer) error {
+	doc := storage.Dump{Version: storage.DumpVersion}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(prefixesBucket).ForEach(func(_, raw []byte) error {
+			var p storage.Prefix
+			if err := json.Unmarshal(raw, &p); err != nil {
+				return err
+			}
+			doc.Prefixes = append(doc.Prefixes, p)
+			return nil
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("unable to dump prefixes:%w", err)
+	}
+	return json.NewEncoder(w).Encode(doc)
+}
+
+// Restore replaces the bucket's content with the storage.Dump document read
+// from r, inside a single bbolt read-write transaction so a partially applied
+// restore can never leave the bucket half-written. It refuses to run against
+// a non-empty bucket unless overwrite is true.
+func (b *boltStorage) Restore(r io.Reader, overwrite bool) error {
+	var doc storage.Dump
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return fmt.Errorf("unable to decode dump:%w", err)
+	}
+	if doc.Version != storage.DumpVersion {
+		return fmt.Errorf("unsupported dump version:%s", doc.Version)
+	}
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(prefixesBucket)
+		if k, _ := bucket.Cursor().First(); k != nil && !overwrite {
+			return storage.NewNotEmptyError("bucket already holds prefixes, pass overwrite to replace them")
+		}
+		if err := tx.DeleteBucket(prefixesBucket); err != nil {
+			return fmt.Errorf("unable to clear prefixes bucket:%w", err)
+		}
+		bucket, err := tx.CreateBucket(prefixesBucket)
+		if err != nil {
+			return fmt.Errorf("unable to recreate prefixes bucket:%w", err)
+		}
+		for _, p := range doc.Prefixes {
+			p.Version = 0
+			raw, err := json.Marshal(p)
+			if err != nil {
+				return fmt.Errorf("unable to marshal prefix:%w", err)
+			}
+			if err := bucket.Put([]byte(p.Cidr), raw); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+var _ storage.Storage = (*boltStorage)(nil)
+var _ storage.PessimisticLocker = (*boltStorage)(nil)
+var _ storage.LeaseReleaser = (*boltStorage)(nil)
@@ -0,0 +1,153 @@
+package bolt
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/metal-pod/go-ipam/storage"
+)
+
+func newTestDB(t *testing.T) *boltStorage {
+	db, err := New(filepath.Join(t.TempDir(), "ipam.db"))
+	require.Nil(t, err)
+	return db
+}
+
+func Test_bolt_CreateReadUpdateDeletePrefix(t *testing.T) {
+	db := newTestDB(t)
+
+	prefix := storage.Prefix{Cidr: "10.0.0.0/16"}
+	_, err := db.ReadPrefix(prefix.Cidr)
+	require.NotNil(t, err)
+	require.True(t, storage.IsNotFound(err))
+
+	p, err := db.CreatePrefix(prefix)
+	require.Nil(t, err)
+	require.Equal(t, prefix.Cidr, p.Cidr)
+
+	p, err = db.ReadPrefix(prefix.Cidr)
+	require.Nil(t, err)
+	require.Equal(t, prefix.Cidr, p.Cidr)
+
+	p.ParentCidr = "10.0.0.0/8"
+	p, err = db.UpdatePrefix(p)
+	require.Nil(t, err)
+	p, err = db.ReadPrefix(prefix.Cidr)
+	require.Nil(t, err)
+	require.Equal(t, "10.0.0.0/8", p.ParentCidr)
+
+	ps, err := db.ReadAllPrefixes()
+	require.Nil(t, err)
+	require.Equal(t, 1, len(ps))
+
+	_, err = db.DeletePrefix(p)
+	require.Nil(t, err)
+	_, err = db.ReadPrefix(prefix.Cidr)
+	require.True(t, storage.IsNotFound(err))
+}
+
+func Test_bolt_UpdatePrefix_OptimisticLockFailed(t *testing.T) {
+	db := newTestDB(t)
+
+	prefix := storage.Prefix{Cidr: "11.0.0.0/16"}
+	_, err := db.CreatePrefix(prefix)
+	require.Nil(t, err)
+
+	p, err := db.ReadPrefix(prefix.Cidr)
+	require.Nil(t, err)
+
+	// Simulate a concurrent writer updating the row first.
+	stale := p
+	p.ParentCidr = "11.0.0.0/8"
+	_, err = db.UpdatePrefix(p)
+	require.Nil(t, err)
+
+	stale.ParentCidr = "11.0.0.0/4"
+	_, err = db.UpdatePrefix(stale)
+	require.NotNil(t, err)
+	require.True(t, storage.IsOptimisticLockError(err))
+}
+
+func Test_bolt_UpdatePrefixWithLock(t *testing.T) {
+	db := newTestDB(t)
+
+	prefix := storage.Prefix{Cidr: "12.0.0.0/16"}
+	_, err := db.CreatePrefix(prefix)
+	require.Nil(t, err)
+
+	require.True(t, db.LockingEnabled())
+
+	updated, err := db.UpdatePrefixWithLock(prefix.Cidr, func(p storage.Prefix) (storage.Prefix, error) {
+		p.ParentCidr = "12.0.0.0/8"
+		return p, nil
+	})
+	require.Nil(t, err)
+	require.Equal(t, "12.0.0.0/8", updated.ParentCidr)
+
+	p, err := db.ReadPrefix(prefix.Cidr)
+	require.Nil(t, err)
+	require.Equal(t, "12.0.0.0/8", p.ParentCidr)
+}
+
+func Test_bolt_DumpRestore(t *testing.T) {
+	db := newTestDB(t)
+
+	_, err := db.CreatePrefix(storage.Prefix{Cidr: "13.0.0.0/16"})
+	require.Nil(t, err)
+	_, err = db.CreatePrefix(storage.Prefix{Cidr: "13.0.0.0/24", ParentCidr: "13.0.0.0/16"})
+	require.Nil(t, err)
+
+	var buf bytes.Buffer
+	err = db.Dump(&buf)
+	require.Nil(t, err)
+
+	// Restoring into the same, non-empty store without overwrite must fail.
+	err = db.Restore(bytes.NewReader(buf.Bytes()), false)
+	require.NotNil(t, err)
+	require.True(t, storage.IsNotEmpty(err))
+
+	restored := newTestDB(t)
+	err = restored.Restore(bytes.NewReader(buf.Bytes()), false)
+	require.Nil(t, err)
+	ps, err := restored.ReadAllPrefixes()
+	require.Nil(t, err)
+	require.Equal(t, 2, len(ps))
+
+	err = db.Restore(bytes.NewReader(buf.Bytes()), true)
+	require.Nil(t, err)
+}
+
+func Test_bolt_ReleaseExpiredLease(t *testing.T) {
+	db := newTestDB(t)
+
+	_, err := db.CreatePrefix(storage.Prefix{Cidr: "14.0.0.0/24"})
+	require.Nil(t, err)
+
+	leasedUntil := time.Now().Add(-time.Minute)
+	updated, err := db.UpdatePrefixWithLock("14.0.0.0/24", func(p storage.Prefix) (storage.Prefix, error) {
+		p.LeaseToken = "tok"
+		p.LeasedUntil = leasedUntil
+		return p, nil
+	})
+	require.Nil(t, err)
+	require.Equal(t, "tok", updated.LeaseToken)
+
+	before := time.Now()
+
+	// A stale token must not release the lease.
+	_, released, err := db.ReleaseExpiredLease("14.0.0.0/24", "wrong-token", before)
+	require.Nil(t, err)
+	require.False(t, released)
+
+	deleted, released, err := db.ReleaseExpiredLease("14.0.0.0/24", "tok", before)
+	require.Nil(t, err)
+	require.True(t, released)
+	require.Equal(t, "14.0.0.0/24", deleted.Cidr)
+
+	_, err = db.ReadPrefix("14.0.0.0/24")
+	require.True(t, storage.IsNotFound(err))
+}
@@ -0,0 +1,209 @@
+// Package etcd is a storage.Storage backend backed by etcd, giving
+// distributed callers the same optimistic-concurrency semantics as the SQL
+// backend via a compare-and-swap on the key's ModRevision.
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/metal-pod/go-ipam/storage"
+)
+
+const keyPrefix = "/go-ipam/prefixes/"
+
+// etcdStorage is the storage.Storage implementation backed by etcd.
+type etcdStorage struct {
+	client *clientv3.Client
+}
+
+// New creates a new Storage backed by the given etcd endpoints.
+func New(endpoints []string) (*etcdStorage, error) {
+	client, err := clientv3.New(clientv3.Config{Endpoints: endpoints})
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to etcd:%w", err)
+	}
+	return &etcdStorage{client: client}, nil
+}
+
+func key(cidr string) string {
+	return keyPrefix + cidr
+}
+
+// CreatePrefix persists a new Prefix, returning the unchanged Prefix on success.
+func (e *etcdStorage) CreatePrefix(prefix storage.Prefix) (storage.Prefix, error) {
+	raw, err := json.Marshal(prefix)
+	if err != nil {
+		return storage.Prefix{}, fmt.Errorf("unable to marshal prefix:%w", err)
+	}
+	ctx := context.Background()
+	_, err = e.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key(prefix.Cidr)), "=", 0)).
+		Then(clientv3.OpPut(key(prefix.Cidr), string(raw))).
+		Commit()
+	if err != nil {
+		return storage.Prefix{}, fmt.Errorf("unable to create prefix:%w", err)
+	}
+	return prefix, nil
+}
+
+// ReadPrefix reads a Prefix by cidr. The returned Prefix carries the key's
+// current ModRevision (as Version) so a subsequent UpdatePrefix can detect a
+// concurrent modification.
+func (e *etcdStorage) ReadPrefix(cidr string) (storage.Prefix, error) {
+	resp, err := e.client.Get(context.Background(), key(cidr))
+	if err != nil {
+		return storage.Prefix{}, fmt.Errorf("unable to read prefix:%w", err)
+	}
+	if len(resp.Kvs) == 0 {
+		return storage.Prefix{}, storage.NewNotFoundError("unable to read prefix:%s", cidr)
+	}
+	var p storage.Prefix
+	if err := json.Unmarshal(resp.Kvs[0].Value, &p); err != nil {
+		return storage.Prefix{}, fmt.Errorf("unable to unmarshal prefix:%w", err)
+	}
+	p.Version = resp.Kvs[0].ModRevision
+	return p, nil
+}
+
+// ReadAllPrefixes returns every Prefix currently stored.
+func (e *etcdStorage) ReadAllPrefixes() ([]storage.Prefix, error) {
+	resp, err := e.client.Get(context.Background(), keyPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, fmt.Errorf("unable to read prefixes:%w", err)
+	}
+	result := make([]storage.Prefix, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		var p storage.Prefix
+		if err := json.Unmarshal(kv.Value, &p); err != nil {
+			return nil, fmt.Errorf("unable to unmarshal prefix:%w", err)
+		}
+		p.Version = kv.ModRevision
+		result = append(result, p)
+	}
+	return result, nil
+}
+
+// UpdatePrefix persists changes to an existing Prefix using a compare-and-swap
+// on the key's ModRevision: the update only applies if the key's ModRevision
+// still matches prefix.Version, otherwise storage.ErrOptimisticLockFailed is
+// returned so the caller can re-read and retry the mutation.
+func (e *etcdStorage) UpdatePrefix(prefix storage.Prefix) (storage.Prefix, error) {
+	raw, err := json.Marshal(prefix)
+	if err != nil {
+		return storage.Prefix{}, fmt.Errorf("unable to marshal prefix:%w", err)
+	}
+	k := key(prefix.Cidr)
+	resp, err := e.client.Txn(context.Background()).
+		If(clientv3.Compare(clientv3.ModRevision(k), "=", prefix.Version)).
+		Then(clientv3.OpPut(k, string(raw))).
+		Commit()
+	if err != nil {
+		return storage.Prefix{}, fmt.Errorf("unable to update prefix:%w", err)
+	}
+	if !resp.Succeeded {
+		return storage.Prefix{}, storage.NewOptimisticLockError(prefix.Cidr)
+	}
+	prefix.Version = resp.Header.Revision
+	return prefix, nil
+}
+
+// DeletePrefix removes a Prefix, returning the Prefix as it was before deletion.
+func (e *etcdStorage) DeletePrefix(prefix storage.Prefix) (storage.Prefix, error) {
+	stored, err := e.ReadPrefix(prefix.Cidr)
+	if err != nil {
+		return storage.Prefix{}, fmt.Errorf("unable to delete prefix:%w", err)
+	}
+	_, err = e.client.Delete(context.Background(), key(prefix.Cidr))
+	if err != nil {
+		return storage.Prefix{}, fmt.Errorf("unable to delete prefix:%w", err)
+	}
+	return stored, nil
+}
+
+// ReleaseExpiredLease atomically deletes the key at cidr if it is still held
+// by token, its lease was still expired as of before, and its ModRevision has
+// not changed since the read, returning the deleted Prefix and true. If the
+// key was renewed, released, or is already gone, it returns false without
+// deleting anything.
+func (e *etcdStorage) ReleaseExpiredLease(cidr, token string, before time.Time) (storage.Prefix, bool, error) {
+	k := key(cidr)
+	resp, err := e.client.Get(context.Background(), k)
+	if err != nil {
+		return storage.Prefix{}, false, fmt.Errorf("unable to release expired lease:%w", err)
+	}
+	if len(resp.Kvs) == 0 {
+		return storage.Prefix{}, false, nil
+	}
+	var p storage.Prefix
+	if err := json.Unmarshal(resp.Kvs[0].Value, &p); err != nil {
+		return storage.Prefix{}, false, fmt.Errorf("unable to unmarshal prefix:%w", err)
+	}
+	if p.LeaseToken != token || p.LeasedUntil.IsZero() || !p.LeasedUntil.Before(before) {
+		return storage.Prefix{}, false, nil
+	}
+	txnResp, err := e.client.Txn(context.Background()).
+		If(clientv3.Compare(clientv3.ModRevision(k), "=", resp.Kvs[0].ModRevision)).
+		Then(clientv3.OpDelete(k)).
+		Commit()
+	if err != nil {
+		return storage.Prefix{}, false, fmt.Errorf("unable to release expired lease:%w", err)
+	}
+	if !txnResp.Succeeded {
+		return storage.Prefix{}, false, nil
+	}
+	return p, true, nil
+}
+
+// Dump writes every stored Prefix as a storage.Dump document to w.
+func (e *etcdStorage) Dump(w io.Writer) error {
+	prefixes, err := e.ReadAllPrefixes()
+	if err != nil {
+		return fmt.Errorf("unable to dump prefixes:%w", err)
+	}
+	doc := storage.Dump{Version: storage.DumpVersion, Prefixes: prefixes}
+	return json.NewEncoder(w).Encode(doc)
+}
+
+// Restore replaces every key under keyPrefix with the storage.Dump document
+// read from r. It refuses to run against a non-empty keyspace unless
+// overwrite is true.
+func (e *etcdStorage) Restore(r io.Reader, overwrite bool) error {
+	var doc storage.Dump
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return fmt.Errorf("unable to decode dump:%w", err)
+	}
+	if doc.Version != storage.DumpVersion {
+		return fmt.Errorf("unsupported dump version:%s", doc.Version)
+	}
+
+	ctx := context.Background()
+	if !overwrite {
+		resp, err := e.client.Get(ctx, keyPrefix, clientv3.WithPrefix(), clientv3.WithCountOnly())
+		if err != nil {
+			return fmt.Errorf("unable to check keyspace state:%w", err)
+		}
+		if resp.Count > 0 {
+			return storage.NewNotEmptyError("keyspace already holds %d prefixes, pass overwrite to replace them", resp.Count)
+		}
+	}
+
+	if _, err := e.client.Delete(ctx, keyPrefix, clientv3.WithPrefix()); err != nil {
+		return fmt.Errorf("unable to clear keyspace:%w", err)
+	}
+	for _, prefix := range doc.Prefixes {
+		prefix.Version = 0
+		if _, err := e.CreatePrefix(prefix); err != nil {
+			return fmt.Errorf("unable to restore prefix:%w", err)
+		}
+	}
+	return nil
+}
+
+var _ storage.Storage = (*etcdStorage)(nil)
+var _ storage.LeaseReleaser = (*etcdStorage)(nil)
@@ -0,0 +1,152 @@
+package etcd
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/metal-pod/go-ipam/storage"
+)
+
+// requireEtcd connects to a local etcd instance, or skips the test when none
+// is reachable so `go test ./...` doesn't require a running etcd on
+// localhost:2379.
+func requireEtcd(t *testing.T) *etcdStorage {
+	db, err := New([]string{"localhost:2379"})
+	if err != nil {
+		t.Skipf("no etcd reachable on localhost:2379, skipping: %s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, err := db.client.Get(ctx, "healthcheck"); err != nil {
+		t.Skipf("no etcd reachable on localhost:2379, skipping: %s", err)
+	}
+	return db
+}
+
+func destroy(db *etcdStorage) {
+	_, _ = db.client.Delete(context.Background(), keyPrefix, clientv3.WithPrefix())
+}
+
+func Test_etcd_CreateReadUpdateDeletePrefix(t *testing.T) {
+	db := requireEtcd(t)
+	defer destroy(db)
+
+	prefix := storage.Prefix{Cidr: "10.0.0.0/16"}
+	_, err := db.ReadPrefix(prefix.Cidr)
+	require.NotNil(t, err)
+	require.True(t, storage.IsNotFound(err))
+
+	p, err := db.CreatePrefix(prefix)
+	require.Nil(t, err)
+	require.Equal(t, prefix.Cidr, p.Cidr)
+
+	p, err = db.ReadPrefix(prefix.Cidr)
+	require.Nil(t, err)
+	require.Equal(t, prefix.Cidr, p.Cidr)
+
+	p.ParentCidr = "10.0.0.0/8"
+	p, err = db.UpdatePrefix(p)
+	require.Nil(t, err)
+	p, err = db.ReadPrefix(prefix.Cidr)
+	require.Nil(t, err)
+	require.Equal(t, "10.0.0.0/8", p.ParentCidr)
+
+	ps, err := db.ReadAllPrefixes()
+	require.Nil(t, err)
+	require.Equal(t, 1, len(ps))
+
+	_, err = db.DeletePrefix(p)
+	require.Nil(t, err)
+	_, err = db.ReadPrefix(prefix.Cidr)
+	require.True(t, storage.IsNotFound(err))
+}
+
+func Test_etcd_UpdatePrefix_OptimisticLockFailed(t *testing.T) {
+	db := requireEtcd(t)
+	defer destroy(db)
+
+	prefix := storage.Prefix{Cidr: "11.0.0.0/16"}
+	_, err := db.CreatePrefix(prefix)
+	require.Nil(t, err)
+
+	p, err := db.ReadPrefix(prefix.Cidr)
+	require.Nil(t, err)
+
+	// Simulate a concurrent writer updating the key first.
+	stale := p
+	p.ParentCidr = "11.0.0.0/8"
+	_, err = db.UpdatePrefix(p)
+	require.Nil(t, err)
+
+	stale.ParentCidr = "11.0.0.0/4"
+	_, err = db.UpdatePrefix(stale)
+	require.NotNil(t, err)
+	require.True(t, storage.IsOptimisticLockError(err))
+}
+
+func Test_etcd_DumpRestore(t *testing.T) {
+	db := requireEtcd(t)
+	defer destroy(db)
+
+	_, err := db.CreatePrefix(storage.Prefix{Cidr: "12.0.0.0/16"})
+	require.Nil(t, err)
+	_, err = db.CreatePrefix(storage.Prefix{Cidr: "12.0.0.0/24", ParentCidr: "12.0.0.0/16"})
+	require.Nil(t, err)
+
+	var buf bytes.Buffer
+	err = db.Dump(&buf)
+	require.Nil(t, err)
+
+	// Restoring into the same, non-empty keyspace without overwrite must fail.
+	err = db.Restore(bytes.NewReader(buf.Bytes()), false)
+	require.NotNil(t, err)
+	require.True(t, storage.IsNotEmpty(err))
+
+	err = db.Restore(bytes.NewReader(buf.Bytes()), true)
+	require.Nil(t, err)
+	ps, err := db.ReadAllPrefixes()
+	require.Nil(t, err)
+	require.Equal(t, 2, len(ps))
+}
+
+func Test_etcd_ReleaseExpiredLease(t *testing.T) {
+	db := requireEtcd(t)
+	defer destroy(db)
+
+	// No key at all for this cidr: must report released=false, not an error.
+	_, released, err := db.ReleaseExpiredLease("13.0.0.0/24", "tok", time.Now())
+	require.Nil(t, err)
+	require.False(t, released)
+
+	prefix := storage.Prefix{Cidr: "13.0.0.0/24"}
+	_, err = db.CreatePrefix(prefix)
+	require.Nil(t, err)
+
+	current, err := db.ReadPrefix(prefix.Cidr)
+	require.Nil(t, err)
+	current.LeaseToken = "tok"
+	current.LeasedUntil = time.Now().Add(-time.Minute)
+	updated, err := db.UpdatePrefix(current)
+	require.Nil(t, err)
+	require.Equal(t, "tok", updated.LeaseToken)
+
+	before := time.Now()
+
+	// A stale token must not release the lease.
+	_, released, err = db.ReleaseExpiredLease(prefix.Cidr, "wrong-token", before)
+	require.Nil(t, err)
+	require.False(t, released)
+
+	deleted, released, err := db.ReleaseExpiredLease(prefix.Cidr, "tok", before)
+	require.Nil(t, err)
+	require.True(t, released)
+	require.Equal(t, prefix.Cidr, deleted.Cidr)
+
+	_, err = db.ReadPrefix(prefix.Cidr)
+	require.True(t, storage.IsNotFound(err))
+}
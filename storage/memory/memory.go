@@ -0,0 +1,184 @@
+// Package memory is an in-memory storage.Storage backend, backed by a map
+// guarded by a sync.RWMutex. It is intended for single-node deployments and
+// for tests that would otherwise need a running Postgres instance.
+package memory
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/metal-pod/go-ipam/storage"
+)
+
+// memory is the storage.Storage implementation backed by an in-process map.
+type memory struct {
+	mu       sync.RWMutex
+	prefixes map[string]storage.Prefix
+}
+
+// New creates a new empty in-memory Storage.
+func New() *memory {
+	return &memory{prefixes: make(map[string]storage.Prefix)}
+}
+
+func clone(p storage.Prefix) storage.Prefix {
+	available := make(map[string]bool, len(p.AvailableChildPrefixes))
+	for k, v := range p.AvailableChildPrefixes {
+		available[k] = v
+	}
+	ips := make(map[string]bool, len(p.IPs))
+	for k, v := range p.IPs {
+		ips[k] = v
+	}
+	p.AvailableChildPrefixes = available
+	p.IPs = ips
+	return p
+}
+
+// CreatePrefix persists a new Prefix, returning the unchanged Prefix on success.
+func (m *memory) CreatePrefix(prefix storage.Prefix) (storage.Prefix, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.prefixes[prefix.Cidr]; ok {
+		return m.prefixes[prefix.Cidr], nil
+	}
+	m.prefixes[prefix.Cidr] = clone(prefix)
+	return m.prefixes[prefix.Cidr], nil
+}
+
+// ReadPrefix reads a Prefix by cidr.
+func (m *memory) ReadPrefix(cidr string) (storage.Prefix, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	p, ok := m.prefixes[cidr]
+	if !ok {
+		return storage.Prefix{}, storage.NewNotFoundError("unable to read prefix:%s", cidr)
+	}
+	return clone(p), nil
+}
+
+// ReadAllPrefixes returns every Prefix currently stored.
+func (m *memory) ReadAllPrefixes() ([]storage.Prefix, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	result := make([]storage.Prefix, 0, len(m.prefixes))
+	for _, p := range m.prefixes {
+		result = append(result, clone(p))
+	}
+	return result, nil
+}
+
+// UpdatePrefix persists changes to an existing Prefix using optimistic
+// locking: the update only applies if the stored version still matches
+// prefix.Version, otherwise storage.ErrOptimisticLockFailed is returned so
+// the caller can re-read and retry the mutation.
+func (m *memory) UpdatePrefix(prefix storage.Prefix) (storage.Prefix, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	current, ok := m.prefixes[prefix.Cidr]
+	if !ok {
+		return storage.Prefix{}, storage.NewNotFoundError("unable to update prefix:%s", prefix.Cidr)
+	}
+	if current.Version != prefix.Version {
+		return storage.Prefix{}, storage.NewOptimisticLockError(prefix.Cidr)
+	}
+	prefix.Version++
+	m.prefixes[prefix.Cidr] = clone(prefix)
+	return m.prefixes[prefix.Cidr], nil
+}
+
+// DeletePrefix removes a Prefix, returning the Prefix as it was before deletion.
+func (m *memory) DeletePrefix(prefix storage.Prefix) (storage.Prefix, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.prefixes[prefix.Cidr]
+	if !ok {
+		return storage.Prefix{}, storage.NewNotFoundError("unable to delete prefix:%s", prefix.Cidr)
+	}
+	delete(m.prefixes, prefix.Cidr)
+	return p, nil
+}
+
+// LockingEnabled always reports true: the whole read-modify-write already
+// happens under mu, so Ipamer should use UpdatePrefixWithLock rather than its
+// optimistic retry loop, which would be wasted work here.
+func (m *memory) LockingEnabled() bool {
+	return true
+}
+
+// UpdatePrefixWithLock runs mutate against the current value while holding mu
+// for the whole read-modify-write, so it is atomic without needing a version
+// check.
+func (m *memory) UpdatePrefixWithLock(cidr string, mutate func(storage.Prefix) (storage.Prefix, error)) (storage.Prefix, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.prefixes[cidr]
+	if !ok {
+		return storage.Prefix{}, storage.NewNotFoundError("unable to read prefix:%s", cidr)
+	}
+	mutated, err := mutate(clone(p))
+	if err != nil {
+		return storage.Prefix{}, err
+	}
+	mutated.Version++
+	m.prefixes[cidr] = clone(mutated)
+	return m.prefixes[cidr], nil
+}
+
+// ReleaseExpiredLease atomically deletes the prefix at cidr if it is still
+// held by token and its lease was still expired as of before, returning the
+// deleted Prefix and true. If the entry was renewed, released, or is already
+// gone, it returns false without deleting anything.
+func (m *memory) ReleaseExpiredLease(cidr, token string, before time.Time) (storage.Prefix, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.prefixes[cidr]
+	if !ok || p.LeaseToken != token || p.LeasedUntil.IsZero() || !p.LeasedUntil.Before(before) {
+		return storage.Prefix{}, false, nil
+	}
+	delete(m.prefixes, cidr)
+	return clone(p), true, nil
+}
+
+// Dump writes every stored Prefix as a storage.Dump document to w.
+func (m *memory) Dump(w io.Writer) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	doc := storage.Dump{Version: storage.DumpVersion, Prefixes: make([]storage.Prefix, 0, len(m.prefixes))}
+	for _, p := range m.prefixes {
+		doc.Prefixes = append(doc.Prefixes, clone(p))
+	}
+	return json.NewEncoder(w).Encode(doc)
+}
+
+// Restore replaces the store's content with the storage.Dump document read
+// from r. It refuses to run against a non-empty store unless overwrite is true.
+func (m *memory) Restore(r io.Reader, overwrite bool) error {
+	var doc storage.Dump
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return fmt.Errorf("unable to decode dump:%w", err)
+	}
+	if doc.Version != storage.DumpVersion {
+		return fmt.Errorf("unsupported dump version:%s", doc.Version)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.prefixes) > 0 && !overwrite {
+		return storage.NewNotEmptyError("store already holds %d prefixes, pass overwrite to replace them", len(m.prefixes))
+	}
+	restored := make(map[string]storage.Prefix, len(doc.Prefixes))
+	for _, p := range doc.Prefixes {
+		p.Version = 0
+		restored[p.Cidr] = clone(p)
+	}
+	m.prefixes = restored
+	return nil
+}
+
+var _ storage.Storage = (*memory)(nil)
+var _ storage.PessimisticLocker = (*memory)(nil)
+var _ storage.LeaseReleaser = (*memory)(nil)
@@ -0,0 +1,81 @@
+package memory
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	ipam "github.com/metal-pod/go-ipam"
+	"github.com/metal-pod/go-ipam/storage"
+)
+
+func Test_memory_CreateReadUpdateDeletePrefix(t *testing.T) {
+	db := New()
+
+	prefix := storage.Prefix{Cidr: "10.0.0.0/16"}
+	_, err := db.ReadPrefix(prefix.Cidr)
+	require.NotNil(t, err)
+	require.True(t, storage.IsNotFound(err))
+
+	p, err := db.CreatePrefix(prefix)
+	require.Nil(t, err)
+	require.Equal(t, prefix.Cidr, p.Cidr)
+
+	p, err = db.ReadPrefix(prefix.Cidr)
+	require.Nil(t, err)
+	require.Equal(t, prefix.Cidr, p.Cidr)
+
+	p.ParentCidr = "10.0.0.0/8"
+	p, err = db.UpdatePrefix(p)
+	require.Nil(t, err)
+	p, err = db.ReadPrefix(prefix.Cidr)
+	require.Nil(t, err)
+	require.Equal(t, "10.0.0.0/8", p.ParentCidr)
+
+	ps, err := db.ReadAllPrefixes()
+	require.Nil(t, err)
+	require.Equal(t, 1, len(ps))
+
+	_, err = db.DeletePrefix(p)
+	require.Nil(t, err)
+	_, err = db.ReadPrefix(prefix.Cidr)
+	require.True(t, storage.IsNotFound(err))
+}
+
+// Test_ConcurrentAcquirePrefix asserts that N goroutines racing to acquire a
+// child prefix from the same in-memory parent each end up with a distinct
+// prefix, without needing to poll or sleep.
+func Test_ConcurrentAcquirePrefix(t *testing.T) {
+	ipamer := ipam.NewWithStorage(New())
+
+	const parent = "1.0.0.0/16"
+	_, err := ipamer.NewPrefix(parent)
+	require.Nil(t, err)
+
+	var wg sync.WaitGroup
+	count := 10
+	wg.Add(count)
+
+	results := make(chan string, count)
+	for i := 0; i < count; i++ {
+		go func() {
+			defer wg.Done()
+			p := ipamer.PrefixFrom(parent)
+			require.NotNil(t, p)
+			cp, err := ipamer.AcquireChildPrefix(p, 24)
+			require.Nil(t, err)
+			require.NotNil(t, cp)
+			results <- cp.String()
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	seen := make(map[string]bool)
+	for cidr := range results {
+		require.False(t, seen[cidr], "prefix %s was handed out twice", cidr)
+		seen[cidr] = true
+	}
+	require.Equal(t, count, len(seen))
+}
@@ -0,0 +1,52 @@
+package postgres
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	ipam "github.com/metal-pod/go-ipam"
+)
+
+// Test_ConcurrentAcquirePrefix asserts that N goroutines racing to acquire a
+// child prefix from the same parent each end up with a distinct prefix. The
+// optimistic-locking retry loop in Ipamer.AcquireChildPrefix makes this
+// deterministic: no goroutine needs to poll or sleep.
+func Test_ConcurrentAcquirePrefix(t *testing.T) {
+	db := requireDB(t)
+
+	ipamer := ipam.NewWithStorage(db)
+
+	const parent = "1.0.0.0/16"
+	_, err := ipamer.NewPrefix(parent)
+	require.Nil(t, err)
+
+	var wg sync.WaitGroup
+	count := 10
+	wg.Add(count)
+
+	results := make(chan string, count)
+	for i := 0; i < count; i++ {
+		go func() {
+			defer wg.Done()
+			p := ipamer.PrefixFrom(parent)
+			require.NotNil(t, p)
+			cp, err := ipamer.AcquireChildPrefix(p, 24)
+			require.Nil(t, err)
+			require.NotNil(t, cp)
+			results <- cp.String()
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	seen := make(map[string]bool)
+	for cidr := range results {
+		require.False(t, seen[cidr], "prefix %s was handed out twice", cidr)
+		seen[cidr] = true
+	}
+	require.Equal(t, count, len(seen))
+
+	destroy(db)
+}
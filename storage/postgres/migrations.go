@@ -0,0 +1,117 @@
+package postgres
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed migrations/*.up.sql
+var migrationsFS embed.FS
+
+// migration is one numbered, forward-only step applied to the prefixes
+// schema. The down.sql counterparts live alongside the up.sql files for
+// operators who need to roll back by hand; the runner itself only applies up.
+type migration struct {
+	version int
+	name    string
+	up      string
+}
+
+// schemaVersion is the highest migration version this build of the package
+// knows about. NewPostgresStorage refuses to start against a database whose
+// schema_migrations table reports a higher version, since that means the
+// database was migrated by newer code than what's running.
+var schemaVersion = func() int {
+	migrations, err := loadMigrations()
+	if err != nil {
+		panic(err)
+	}
+	max := 0
+	for _, m := range migrations {
+		if m.version > max {
+			max = m.version
+		}
+	}
+	return max
+}()
+
+func loadMigrations() ([]migration, error) {
+	entries, err := fs.ReadDir(migrationsFS, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("unable to read embedded migrations:%w", err)
+	}
+	migrations := make([]migration, 0, len(entries))
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasSuffix(name, ".up.sql") {
+			continue
+		}
+		versionStr := strings.SplitN(name, "_", 2)[0]
+		version, err := strconv.Atoi(versionStr)
+		if err != nil {
+			return nil, fmt.Errorf("migration file %s does not start with a numeric version:%w", name, err)
+		}
+		raw, err := migrationsFS.ReadFile("migrations/" + name)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read migration %s:%w", name, err)
+		}
+		migrations = append(migrations, migration{version: version, name: name, up: string(raw)})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	return migrations, nil
+}
+
+// migrate ensures the schema_migrations bookkeeping table exists, then
+// applies every migration newer than the recorded version, each in its own
+// transaction. It refuses to run if the recorded version is already ahead of
+// schemaVersion, i.e. the database was migrated by a newer build.
+func (p *postgres) migrate() error {
+	_, err := p.db.Exec(`
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version bigint PRIMARY KEY,
+	applied_at timestamptz NOT NULL DEFAULT now()
+)`)
+	if err != nil {
+		return fmt.Errorf("unable to create schema_migrations table:%w", err)
+	}
+
+	var current int
+	err = p.db.Get(&current, "SELECT COALESCE(max(version), 0) FROM schema_migrations")
+	if err != nil {
+		return fmt.Errorf("unable to read current schema version:%w", err)
+	}
+	if current > schemaVersion {
+		return fmt.Errorf("database schema is at version %d, newer than the %d this build knows about; refusing to start", current, schemaVersion)
+	}
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		tx, err := p.db.BeginTxx(context.Background(), nil)
+		if err != nil {
+			return fmt.Errorf("unable to begin migration %s:%w", m.name, err)
+		}
+		if _, err := tx.Exec(m.up); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("unable to apply migration %s:%w", m.name, err)
+		}
+		if _, err := tx.Exec("INSERT INTO schema_migrations (version) VALUES ($1)", m.version); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("unable to record migration %s:%w", m.name, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("unable to commit migration %s:%w", m.name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+package postgres
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_loadMigrations(t *testing.T) {
+	migrations, err := loadMigrations()
+	require.Nil(t, err)
+	require.NotEmpty(t, migrations)
+
+	for i := 1; i < len(migrations); i++ {
+		require.Less(t, migrations[i-1].version, migrations[i].version, "migrations must be sorted and uniquely numbered")
+	}
+	require.Equal(t, migrations[len(migrations)-1].version, schemaVersion)
+}
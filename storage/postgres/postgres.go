@@ -0,0 +1,407 @@
+// Package postgres is a storage.Storage backend that persists prefixes to
+// PostgreSQL or CockroachDB.
+package postgres
+
+import (
+	"context"
+	dbsql "database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq" // PostgreSQL driver
+
+	"github.com/metal-pod/go-ipam/storage"
+)
+
+// lockMode selects how concurrent writers to the same prefix are serialized.
+type lockMode int
+
+const (
+	// optimisticLock retries on a version mismatch detected by UpdatePrefix.
+	optimisticLock lockMode = iota
+	// pessimisticLock takes a `SELECT ... FOR UPDATE` row lock inside a
+	// serializable transaction for the duration of the read-modify-write.
+	pessimisticLock
+)
+
+// postgres is the storage.Storage implementation backed by PostgreSQL/CockroachDB.
+type postgres struct {
+	db       *sqlx.DB
+	lockMode lockMode
+}
+
+// Option configures a Storage returned from New.
+type Option func(*postgres)
+
+// WithPessimisticLocking switches the read-modify-write cycle used by
+// storage.PessimisticLocker callers to a `SELECT ... FOR UPDATE` row lock
+// instead of retrying on a version conflict. Prefer this on Postgres/
+// CockroachDB when contention on a single parent prefix is expected to be
+// high enough that optimistic retries would rarely succeed on the first try.
+func WithPessimisticLocking() Option {
+	return func(p *postgres) {
+		p.lockMode = pessimisticLock
+	}
+}
+
+// New creates a new storage.Storage that persists prefixes to PostgreSQL.
+func New(host, port, user, password, dbname, sslmode string, opts ...Option) (*postgres, error) {
+	dataSource := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s", host, port, user, password, dbname, sslmode)
+	db, err := sqlx.Connect("postgres", dataSource)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to database:%w", err)
+	}
+	p := &postgres{db: db, lockMode: optimisticLock}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if err := p.migrate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// prefixJSON is the on-disk shape of a prefix, excluding the version column
+// which is tracked separately for optimistic locking.
+type prefixJSON struct {
+	Cidr                   string          `json:"cidr"`
+	ParentCidr             string          `json:"parent_cidr"`
+	AvailableChildPrefixes map[string]bool `json:"available_child_prefixes"`
+	ChildPrefixLength      int             `json:"child_prefix_length"`
+	IPs                    map[string]bool `json:"ips"`
+}
+
+func toPrefixJSON(p storage.Prefix) prefixJSON {
+	return prefixJSON{
+		Cidr:                   p.Cidr,
+		ParentCidr:             p.ParentCidr,
+		AvailableChildPrefixes: p.AvailableChildPrefixes,
+		ChildPrefixLength:      p.ChildPrefixLength,
+		IPs:                    p.IPs,
+	}
+}
+
+func fromPrefixJSON(pj prefixJSON, version int64, leasedUntil dbsql.NullTime, leaseToken dbsql.NullString) storage.Prefix {
+	p := storage.Prefix{
+		Cidr:                   pj.Cidr,
+		ParentCidr:             pj.ParentCidr,
+		AvailableChildPrefixes: pj.AvailableChildPrefixes,
+		ChildPrefixLength:      pj.ChildPrefixLength,
+		IPs:                    pj.IPs,
+		Version:                version,
+	}
+	if leasedUntil.Valid {
+		p.LeasedUntil = leasedUntil.Time
+	}
+	if leaseToken.Valid {
+		p.LeaseToken = leaseToken.String
+	}
+	return p
+}
+
+// nullTime converts a possibly-zero time.Time into the NULL-able value
+// the leased_until column expects.
+func nullTime(t time.Time) dbsql.NullTime {
+	if t.IsZero() {
+		return dbsql.NullTime{}
+	}
+	return dbsql.NullTime{Time: t, Valid: true}
+}
+
+// nullString converts a possibly-empty string into the NULL-able value
+// the lease_token column expects.
+func nullString(s string) dbsql.NullString {
+	if s == "" {
+		return dbsql.NullString{}
+	}
+	return dbsql.NullString{String: s, Valid: true}
+}
+
+// prefixExists checks if the given Prefix already exists, returning the stored Prefix if so.
+func (p *postgres) prefixExists(prefix storage.Prefix) (storage.Prefix, bool) {
+	stored, err := p.ReadPrefix(prefix.Cidr)
+	if err != nil {
+		return storage.Prefix{}, false
+	}
+	return stored, true
+}
+
+// CreatePrefix persists a new Prefix, returning the unchanged Prefix on success.
+func (p *postgres) CreatePrefix(prefix storage.Prefix) (storage.Prefix, error) {
+	raw, err := json.Marshal(toPrefixJSON(prefix))
+	if err != nil {
+		return storage.Prefix{}, fmt.Errorf("unable to marshal prefix:%w", err)
+	}
+	_, err = p.db.Exec(
+		"INSERT INTO prefixes (cidr, prefix, version, leased_until, lease_token) VALUES ($1, $2, 0, $3, $4) ON CONFLICT (cidr) DO NOTHING",
+		prefix.Cidr, raw, nullTime(prefix.LeasedUntil), nullString(prefix.LeaseToken))
+	if err != nil {
+		return storage.Prefix{}, fmt.Errorf("unable to create prefix:%w", err)
+	}
+	return prefix, nil
+}
+
+// ReadPrefix reads a Prefix by cidr. The returned Prefix carries the row's
+// current version so a subsequent UpdatePrefix can detect a concurrent
+// modification. If no row exists for cidr, the returned error satisfies
+// storage.IsNotFound, like every other backend.
+func (p *postgres) ReadPrefix(cidr string) (storage.Prefix, error) {
+	var raw []byte
+	var version int64
+	var leasedUntil dbsql.NullTime
+	var leaseToken dbsql.NullString
+	row := p.db.QueryRow("SELECT prefix, version, leased_until, lease_token FROM prefixes WHERE cidr=$1", cidr)
+	if err := row.Scan(&raw, &version, &leasedUntil, &leaseToken); err != nil {
+		if errors.Is(err, dbsql.ErrNoRows) {
+			return storage.Prefix{}, storage.NewNotFoundError("unable to read prefix:%s", err)
+		}
+		return storage.Prefix{}, fmt.Errorf("unable to read prefix:%w", err)
+	}
+	var pj prefixJSON
+	if err := json.Unmarshal(raw, &pj); err != nil {
+		return storage.Prefix{}, fmt.Errorf("unable to unmarshal prefix:%w", err)
+	}
+	return fromPrefixJSON(pj, version, leasedUntil, leaseToken), nil
+}
+
+// ReadAllPrefixes returns every Prefix currently stored.
+func (p *postgres) ReadAllPrefixes() ([]storage.Prefix, error) {
+	rows, err := p.db.Query("SELECT prefix, version, leased_until, lease_token FROM prefixes")
+	if err != nil {
+		return nil, fmt.Errorf("unable to read prefixes:%w", err)
+	}
+	defer rows.Close()
+	var result []storage.Prefix
+	for rows.Next() {
+		var raw []byte
+		var version int64
+		var leasedUntil dbsql.NullTime
+		var leaseToken dbsql.NullString
+		if err := rows.Scan(&raw, &version, &leasedUntil, &leaseToken); err != nil {
+			return nil, fmt.Errorf("unable to read prefixes:%w", err)
+		}
+		var pj prefixJSON
+		if err := json.Unmarshal(raw, &pj); err != nil {
+			return nil, fmt.Errorf("unable to unmarshal prefix:%w", err)
+		}
+		result = append(result, fromPrefixJSON(pj, version, leasedUntil, leaseToken))
+	}
+	return result, nil
+}
+
+// ReadLeasedPrefixes returns every Prefix whose lease expired before before,
+// using the indexed leased_until column so the lease reaper doesn't have to
+// scan the whole table on every sweep.
+func (p *postgres) ReadLeasedPrefixes(before time.Time) ([]storage.Prefix, error) {
+	rows, err := p.db.Query("SELECT prefix, version, leased_until, lease_token FROM prefixes WHERE leased_until IS NOT NULL AND leased_until < $1", before)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read leased prefixes:%w", err)
+	}
+	defer rows.Close()
+	var result []storage.Prefix
+	for rows.Next() {
+		var raw []byte
+		var version int64
+		var leasedUntil dbsql.NullTime
+		var leaseToken dbsql.NullString
+		if err := rows.Scan(&raw, &version, &leasedUntil, &leaseToken); err != nil {
+			return nil, fmt.Errorf("unable to read leased prefixes:%w", err)
+		}
+		var pj prefixJSON
+		if err := json.Unmarshal(raw, &pj); err != nil {
+			return nil, fmt.Errorf("unable to unmarshal prefix:%w", err)
+		}
+		result = append(result, fromPrefixJSON(pj, version, leasedUntil, leaseToken))
+	}
+	return result, nil
+}
+
+// UpdatePrefix persists changes to an existing Prefix using optimistic locking:
+// the update only applies if the row's version still matches prefix.Version,
+// i.e. the version that was loaded by the preceding ReadPrefix. Otherwise
+// storage.ErrOptimisticLockFailed is returned so the caller can re-read and
+// retry the mutation.
+func (p *postgres) UpdatePrefix(prefix storage.Prefix) (storage.Prefix, error) {
+	raw, err := json.Marshal(toPrefixJSON(prefix))
+	if err != nil {
+		return storage.Prefix{}, fmt.Errorf("unable to marshal prefix:%w", err)
+	}
+	res, err := p.db.Exec(
+		"UPDATE prefixes SET prefix=$1, version=version+1, leased_until=$2, lease_token=$3 WHERE cidr=$4 AND version=$5",
+		raw, nullTime(prefix.LeasedUntil), nullString(prefix.LeaseToken), prefix.Cidr, prefix.Version)
+	if err != nil {
+		return storage.Prefix{}, fmt.Errorf("unable to update prefix:%w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return storage.Prefix{}, fmt.Errorf("unable to update prefix:%w", err)
+	}
+	if affected == 0 {
+		return storage.Prefix{}, storage.NewOptimisticLockError(prefix.Cidr)
+	}
+	prefix.Version++
+	return prefix, nil
+}
+
+// LockingEnabled reports whether this storage was created with
+// WithPessimisticLocking, in which case Ipamer uses UpdatePrefixWithLock
+// instead of its default optimistic retry loop.
+func (p *postgres) LockingEnabled() bool {
+	return p.lockMode == pessimisticLock
+}
+
+// UpdatePrefixWithLock runs mutate against the current row value while
+// holding a `SELECT ... FOR UPDATE` row lock for the duration of a
+// serializable transaction, so the read-modify-write is atomic without
+// relying on a retry loop.
+func (p *postgres) UpdatePrefixWithLock(cidr string, mutate func(storage.Prefix) (storage.Prefix, error)) (storage.Prefix, error) {
+	tx, err := p.db.BeginTxx(context.Background(), &dbsql.TxOptions{Isolation: dbsql.LevelSerializable})
+	if err != nil {
+		return storage.Prefix{}, fmt.Errorf("unable to begin transaction:%w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var raw []byte
+	var version int64
+	var leasedUntil dbsql.NullTime
+	var leaseToken dbsql.NullString
+	row := tx.QueryRow("SELECT prefix, version, leased_until, lease_token FROM prefixes WHERE cidr=$1 FOR UPDATE", cidr)
+	if err := row.Scan(&raw, &version, &leasedUntil, &leaseToken); err != nil {
+		return storage.Prefix{}, fmt.Errorf("unable to read prefix:%w", err)
+	}
+	var pj prefixJSON
+	if err := json.Unmarshal(raw, &pj); err != nil {
+		return storage.Prefix{}, fmt.Errorf("unable to unmarshal prefix:%w", err)
+	}
+
+	mutated, err := mutate(fromPrefixJSON(pj, version, leasedUntil, leaseToken))
+	if err != nil {
+		return storage.Prefix{}, err
+	}
+
+	newRaw, err := json.Marshal(toPrefixJSON(mutated))
+	if err != nil {
+		return storage.Prefix{}, fmt.Errorf("unable to marshal prefix:%w", err)
+	}
+	if _, err := tx.Exec(
+		"UPDATE prefixes SET prefix=$1, version=version+1, leased_until=$2, lease_token=$3 WHERE cidr=$4",
+		newRaw, nullTime(mutated.LeasedUntil), nullString(mutated.LeaseToken), cidr); err != nil {
+		return storage.Prefix{}, fmt.Errorf("unable to update prefix:%w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return storage.Prefix{}, fmt.Errorf("unable to commit transaction:%w", err)
+	}
+	mutated.Version = version + 1
+	return mutated, nil
+}
+
+// DeletePrefix removes a Prefix, returning the Prefix as it was before deletion.
+func (p *postgres) DeletePrefix(prefix storage.Prefix) (storage.Prefix, error) {
+	stored, err := p.ReadPrefix(prefix.Cidr)
+	if err != nil {
+		return storage.Prefix{}, fmt.Errorf("unable to delete prefix:%w", err)
+	}
+	_, err = p.db.Exec("DELETE FROM prefixes WHERE cidr=$1", prefix.Cidr)
+	if err != nil {
+		return storage.Prefix{}, fmt.Errorf("unable to delete prefix:%w", err)
+	}
+	return stored, nil
+}
+
+// ReleaseExpiredLease atomically deletes the prefix at cidr if its row is
+// still held by token and leased_until is still before before, returning the
+// deleted Prefix and true. If the row was renewed, released, or is already
+// gone, it returns false without deleting anything.
+func (p *postgres) ReleaseExpiredLease(cidr, token string, before time.Time) (storage.Prefix, bool, error) {
+	stored, err := p.ReadPrefix(cidr)
+	if err != nil {
+		if storage.IsNotFound(err) {
+			return storage.Prefix{}, false, nil
+		}
+		return storage.Prefix{}, false, fmt.Errorf("unable to release expired lease:%w", err)
+	}
+	res, err := p.db.Exec(
+		"DELETE FROM prefixes WHERE cidr=$1 AND lease_token=$2 AND leased_until < $3",
+		cidr, token, before)
+	if err != nil {
+		return storage.Prefix{}, false, fmt.Errorf("unable to release expired lease:%w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return storage.Prefix{}, false, fmt.Errorf("unable to release expired lease:%w", err)
+	}
+	if affected == 0 {
+		return storage.Prefix{}, false, nil
+	}
+	return stored, true, nil
+}
+
+// Dump writes every stored Prefix as a storage.Dump document to w.
+func (p *postgres) Dump(w io.Writer) error {
+	prefixes, err := p.ReadAllPrefixes()
+	if err != nil {
+		return fmt.Errorf("unable to dump prefixes:%w", err)
+	}
+	doc := storage.Dump{Version: storage.DumpVersion, Prefixes: prefixes}
+	return json.NewEncoder(w).Encode(doc)
+}
+
+// Restore replaces the table's content with the storage.Dump document read
+// from r. It runs inside a single transaction (BEGIN; TRUNCATE prefixes;
+// INSERT ...; COMMIT) so a partially applied restore can never leave the
+// table in a state the rest of the package has to reason about, and it
+// refuses to run against a non-empty table unless overwrite is true.
+func (p *postgres) Restore(r io.Reader, overwrite bool) error {
+	var doc storage.Dump
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return fmt.Errorf("unable to decode dump:%w", err)
+	}
+	if doc.Version != storage.DumpVersion {
+		return fmt.Errorf("unsupported dump version:%s", doc.Version)
+	}
+
+	if !overwrite {
+		var count int
+		if err := p.db.Get(&count, "SELECT count(*) FROM prefixes"); err != nil {
+			return fmt.Errorf("unable to check table state:%w", err)
+		}
+		if count > 0 {
+			return storage.NewNotEmptyError("table already holds %d prefixes, pass overwrite to replace them", count)
+		}
+	}
+
+	tx, err := p.db.BeginTxx(context.Background(), nil)
+	if err != nil {
+		return fmt.Errorf("unable to begin transaction:%w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec("TRUNCATE prefixes"); err != nil {
+		return fmt.Errorf("unable to truncate prefixes:%w", err)
+	}
+	for _, prefix := range doc.Prefixes {
+		prefix.Version = 0
+		raw, err := json.Marshal(toPrefixJSON(prefix))
+		if err != nil {
+			return fmt.Errorf("unable to marshal prefix:%w", err)
+		}
+		if _, err := tx.Exec(
+			"INSERT INTO prefixes (cidr, prefix, version, leased_until, lease_token) VALUES ($1, $2, 0, $3, $4)",
+			prefix.Cidr, raw, nullTime(prefix.LeasedUntil), nullString(prefix.LeaseToken)); err != nil {
+			return fmt.Errorf("unable to insert prefix:%w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("unable to commit transaction:%w", err)
+	}
+	return nil
+}
+
+var _ storage.Storage = (*postgres)(nil)
+var _ storage.PessimisticLocker = (*postgres)(nil)
+var _ storage.LeaseReader = (*postgres)(nil)
+var _ storage.LeaseReleaser = (*postgres)(nil)
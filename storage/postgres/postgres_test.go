@@ -0,0 +1,206 @@
+package postgres
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/metal-pod/go-ipam/storage"
+)
+
+// requireDB connects to the local test Postgres instance, or skips the test
+// when none is reachable so `go test ./...` doesn't require a running
+// database on localhost:5433.
+func requireDB(t *testing.T) *postgres {
+	db, err := New("localhost", "5433", "postgres", "password", "postgres", "disable")
+	if err != nil {
+		t.Skipf("no postgres reachable on localhost:5433, skipping: %s", err)
+	}
+	if err := db.db.Ping(); err != nil {
+		t.Skipf("no postgres reachable on localhost:5433, skipping: %s", err)
+	}
+	return db
+}
+
+func destroy(db *postgres) {
+	db.db.MustExec("DROP TABLE prefixes")
+	db.db.MustExec("DROP TABLE schema_migrations")
+}
+
+func Test_postgres_prefixExists(t *testing.T) {
+	db := requireDB(t)
+
+	prefix := storage.Prefix{Cidr: "10.0.0.0/16"}
+	p, err := db.CreatePrefix(prefix)
+	require.Nil(t, err)
+	require.Equal(t, prefix.Cidr, p.Cidr)
+	got, exists := db.prefixExists(prefix)
+	require.True(t, exists)
+	require.Equal(t, got.Cidr, prefix.Cidr)
+
+	notExistingPrefix := storage.Prefix{Cidr: "10.0.0.0/8"}
+	_, exists = db.prefixExists(notExistingPrefix)
+	require.False(t, exists)
+
+	_, err = db.DeletePrefix(prefix)
+	require.Nil(t, err)
+	_, exists = db.prefixExists(prefix)
+	require.False(t, exists)
+
+	destroy(db)
+}
+
+func Test_postgres_CreatePrefix(t *testing.T) {
+	db := requireDB(t)
+
+	prefix := storage.Prefix{Cidr: "11.0.0.0/16"}
+	_, exists := db.prefixExists(prefix)
+	require.False(t, exists)
+	p, err := db.CreatePrefix(prefix)
+	require.Nil(t, err)
+	require.Equal(t, prefix.Cidr, p.Cidr)
+	_, exists = db.prefixExists(prefix)
+	require.True(t, exists)
+
+	// Duplicate Prefix
+	p, err = db.CreatePrefix(prefix)
+	require.Nil(t, err)
+	require.Equal(t, prefix.Cidr, p.Cidr)
+
+	ps, err := db.ReadAllPrefixes()
+	require.Nil(t, err)
+	require.Equal(t, 1, len(ps))
+
+	destroy(db)
+}
+
+func Test_postgres_ReadPrefix(t *testing.T) {
+	db := requireDB(t)
+
+	_, err := db.ReadPrefix("12.0.0.0/8")
+	require.NotNil(t, err)
+	require.Equal(t, "unable to read prefix:sql: no rows in result set", err.Error())
+	require.True(t, storage.IsNotFound(err))
+
+	prefix := storage.Prefix{Cidr: "12.0.0.0/16"}
+	_, err = db.CreatePrefix(prefix)
+	require.Nil(t, err)
+
+	p, err := db.ReadPrefix("12.0.0.0/16")
+	require.Nil(t, err)
+	require.Equal(t, "12.0.0.0/16", p.Cidr)
+
+	destroy(db)
+}
+
+func Test_postgres_ReadAllPrefix(t *testing.T) {
+	db := requireDB(t)
+
+	ps, err := db.ReadAllPrefixes()
+	require.Nil(t, err)
+	require.Equal(t, 0, len(ps))
+
+	prefix := storage.Prefix{Cidr: "12.0.0.0/16"}
+	_, err = db.CreatePrefix(prefix)
+	require.Nil(t, err)
+	ps, err = db.ReadAllPrefixes()
+	require.Nil(t, err)
+	require.Equal(t, 1, len(ps))
+
+	_, err = db.DeletePrefix(prefix)
+	require.Nil(t, err)
+	ps, err = db.ReadAllPrefixes()
+	require.Nil(t, err)
+	require.Equal(t, 0, len(ps))
+
+	destroy(db)
+}
+
+func Test_postgres_UpdatePrefix(t *testing.T) {
+	db := requireDB(t)
+
+	prefix := storage.Prefix{Cidr: "13.0.0.0/16", ParentCidr: "13.0.0.0/8"}
+	_, err := db.CreatePrefix(prefix)
+	require.Nil(t, err)
+
+	p, err := db.ReadPrefix("13.0.0.0/16")
+	require.Nil(t, err)
+	require.Equal(t, "13.0.0.0/8", p.ParentCidr)
+
+	p.ParentCidr = "13.0.0.0/12"
+	_, err = db.UpdatePrefix(p)
+	require.Nil(t, err)
+	p, err = db.ReadPrefix("13.0.0.0/16")
+	require.Nil(t, err)
+	require.Equal(t, "13.0.0.0/12", p.ParentCidr)
+
+	destroy(db)
+}
+
+func Test_postgres_ReleaseExpiredLease(t *testing.T) {
+	db := requireDB(t)
+
+	// No row at all for this cidr: must report released=false, not an error.
+	_, released, err := db.ReleaseExpiredLease("15.0.0.0/24", "tok", time.Now())
+	require.Nil(t, err)
+	require.False(t, released)
+
+	prefix := storage.Prefix{Cidr: "15.0.0.0/24"}
+	_, err = db.CreatePrefix(prefix)
+	require.Nil(t, err)
+
+	// lease_token is a uuid column, so the tokens here must be valid UUIDs.
+	const token = "6f1c2b3a-0d4e-4f5a-8b6c-7d8e9f0a1b2c"
+	const wrongToken = "00000000-0000-4000-8000-000000000000"
+
+	leasedUntil := time.Now().Add(-time.Minute)
+	updated, err := db.UpdatePrefixWithLock(prefix.Cidr, func(p storage.Prefix) (storage.Prefix, error) {
+		p.LeaseToken = token
+		p.LeasedUntil = leasedUntil
+		return p, nil
+	})
+	require.Nil(t, err)
+	require.Equal(t, token, updated.LeaseToken)
+
+	before := time.Now()
+
+	// A stale token must not release the lease.
+	_, released, err = db.ReleaseExpiredLease(prefix.Cidr, wrongToken, before)
+	require.Nil(t, err)
+	require.False(t, released)
+
+	deleted, released, err := db.ReleaseExpiredLease(prefix.Cidr, token, before)
+	require.Nil(t, err)
+	require.True(t, released)
+	require.Equal(t, prefix.Cidr, deleted.Cidr)
+
+	_, err = db.ReadPrefix(prefix.Cidr)
+	require.True(t, storage.IsNotFound(err))
+
+	destroy(db)
+}
+
+func Test_postgres_UpdatePrefix_OptimisticLockFailed(t *testing.T) {
+	db := requireDB(t)
+
+	prefix := storage.Prefix{Cidr: "14.0.0.0/16"}
+	_, err := db.CreatePrefix(prefix)
+	require.Nil(t, err)
+
+	p, err := db.ReadPrefix("14.0.0.0/16")
+	require.Nil(t, err)
+
+	// Simulate a concurrent writer updating the row first.
+	stale := p
+	p.ParentCidr = "14.0.0.0/8"
+	_, err = db.UpdatePrefix(p)
+	require.Nil(t, err)
+
+	stale.ParentCidr = "14.0.0.0/4"
+	_, err = db.UpdatePrefix(stale)
+	require.NotNil(t, err)
+	require.True(t, storage.IsOptimisticLockError(err))
+
+	destroy(db)
+}
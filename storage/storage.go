@@ -0,0 +1,144 @@
+// Package storage defines the persistence extension point for go-ipam.
+// Backends under storage/{postgres,bolt,etcd,memory} all implement Storage
+// against the same Prefix representation, so Ipamer can be handed any one of
+// them via NewWithStorage without caring which store is behind it.
+package storage
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// DumpVersion identifies the schema of the document written by Storage.Dump,
+// so a future format change can be detected by Storage.Restore before it
+// tries to interpret prefixes it doesn't understand.
+const DumpVersion = "1"
+
+// Dump is the stable, versioned document Storage.Dump writes and
+// Storage.Restore reads. It is the unit portable across backends: dumping a
+// postgres Storage and restoring into a bolt Storage (or vice versa) is
+// expected to work.
+type Dump struct {
+	Version  string   `json:"version"`
+	Prefixes []Prefix `json:"prefixes"`
+}
+
+// Prefix is the durable representation of a go-ipam prefix. It is the unit
+// every Storage implementation reads and writes; the ipam package wraps it
+// with the higher-level Prefix behaviour (String, acquire/release logic, ...).
+type Prefix struct {
+	Cidr                   string          // The Cidr of this prefix
+	ParentCidr             string          // if this prefix is a child this is a pointer back
+	AvailableChildPrefixes map[string]bool // available child prefixes of this prefix
+	ChildPrefixLength      int             // the length of the child prefixes
+	IPs                    map[string]bool // The ips contained in this prefix
+	Version                int64           // optimistic-locking version, as loaded by ReadPrefix
+	LeasedUntil            time.Time       // zero if this prefix is not leased, otherwise when the lease expires
+	LeaseToken             string          // opaque token identifying the lease, used to renew/release it
+}
+
+// Storage is the interface every backing store must implement to persist
+// Prefixes. Implementations are free to choose how they serialize a Prefix,
+// but must honour the Version field: UpdatePrefix only applies if Version
+// still matches what is stored, otherwise it returns ErrOptimisticLockFailed
+// so the caller can re-read and retry.
+type Storage interface {
+	CreatePrefix(prefix Prefix) (Prefix, error)
+	ReadPrefix(cidr string) (Prefix, error)
+	ReadAllPrefixes() ([]Prefix, error)
+	UpdatePrefix(prefix Prefix) (Prefix, error)
+	DeletePrefix(prefix Prefix) (Prefix, error)
+	// Dump writes every stored Prefix as a Dump document to w.
+	Dump(w io.Writer) error
+	// Restore replaces the store's content with the Dump document read from
+	// r. It refuses to run against a non-empty store unless overwrite is
+	// true.
+	Restore(r io.Reader, overwrite bool) error
+}
+
+// ErrOptimisticLockFailed is returned by UpdatePrefix when the stored version
+// no longer matches the version on the given Prefix, i.e. another writer
+// modified it first.
+type ErrOptimisticLockFailed struct {
+	msg string
+}
+
+func (o ErrOptimisticLockFailed) Error() string { return o.msg }
+
+// NewOptimisticLockError creates a new ErrOptimisticLockFailed for cidr.
+func NewOptimisticLockError(cidr string) error {
+	return ErrOptimisticLockFailed{msg: fmt.Sprintf("prefix:%s was modified concurrently, version mismatch", cidr)}
+}
+
+// IsOptimisticLockError checks if the given error is an ErrOptimisticLockFailed.
+func IsOptimisticLockError(e error) bool {
+	_, ok := e.(ErrOptimisticLockFailed)
+	return ok
+}
+
+// PessimisticLocker is an optional extension of Storage for backends that can
+// run a read-modify-write cycle under a row/row-equivalent lock instead of
+// relying on Storage.UpdatePrefix's optimistic version check. Ipamer prefers
+// this path when the backend opts in and reports LockingEnabled.
+type PessimisticLocker interface {
+	LockingEnabled() bool
+	UpdatePrefixWithLock(cidr string, mutate func(Prefix) (Prefix, error)) (Prefix, error)
+}
+
+// LeaseReader is an optional extension of Storage for backends that can
+// efficiently query only prefixes with an expired lease, instead of the
+// caller having to scan every prefix via ReadAllPrefixes. Ipamer's lease
+// reaper prefers this path when the backend opts in.
+type LeaseReader interface {
+	ReadLeasedPrefixes(before time.Time) ([]Prefix, error)
+}
+
+// LeaseReleaser is an optional extension of Storage for backends that can
+// release an expired lease atomically: the delete only applies if the row
+// at cidr is still held by token and its lease was still expired as of
+// before, otherwise it reports released=false without deleting anything.
+// This closes the race between the reaper snapshotting expired leases and
+// it getting around to releasing each one: a RenewLease that lands on a
+// cidr in between can never be silently wiped out by a stale re-release.
+// Ipamer's lease reaper prefers this path when the backend opts in.
+type LeaseReleaser interface {
+	ReleaseExpiredLease(cidr, token string, before time.Time) (prefix Prefix, released bool, err error)
+}
+
+// ErrNotEmpty is returned by Restore when the store already holds prefixes
+// and the caller did not pass overwrite=true.
+type ErrNotEmpty struct {
+	msg string
+}
+
+func (o ErrNotEmpty) Error() string { return o.msg }
+
+// NewNotEmptyError creates a new ErrNotEmpty with the given formatted message.
+func NewNotEmptyError(format string, args ...interface{}) error {
+	return ErrNotEmpty{msg: fmt.Sprintf(format, args...)}
+}
+
+// IsNotEmpty checks if the given error is an ErrNotEmpty.
+func IsNotEmpty(e error) bool {
+	_, ok := e.(ErrNotEmpty)
+	return ok
+}
+
+// ErrNotFound is returned when a prefix could not be found in the backing store.
+type ErrNotFound struct {
+	msg string
+}
+
+func (o ErrNotFound) Error() string { return o.msg }
+
+// NewNotFoundError creates a new ErrNotFound with the given formatted message.
+func NewNotFoundError(format string, args ...interface{}) error {
+	return ErrNotFound{msg: fmt.Sprintf(format, args...)}
+}
+
+// IsNotFound checks if the given error is an ErrNotFound.
+func IsNotFound(e error) bool {
+	_, ok := e.(ErrNotFound)
+	return ok
+}